@@ -2,112 +2,266 @@ package main
 
 import (
 	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/valyala/fasthttp"
 )
 
+// openRatio is the ratio of failures above which the circuit breaker of a route is open
+const openRatio = 0.3
+
 // Application is an abstraction of radix-tree, timeline, balancer, and configurations...
 type Application struct {
 	// redirect if tsr is true?
 	TSRRedirect bool
 
+	// fixPath is how a request which matches no route is served if its cleaned path matches a
+	// route case-insensitively, redirect, match, or not served if it's empty
+	fixPath string
+
+	// hosts besides the name of the app, which it serves, and it serves requests whose host
+	// matches no app if defaultApp is true
+	hosts      []string
+	defaultApp bool
+
+	// defaultRoute is the leaf which proxies requests matching no route, nil if they're not found
+	defaultRoute *node
+
+	// options answers OPTIONS requests for routes which don't allow OPTIONS, they're proxied
+	// if it's nil
+	options *autoOptions
+
 	balancer        Balancer
-	root            *node
+	root            atomic.Value      // *node, radix tree, it's replaced as a whole when routes change
+	routes          map[string]*route // path -> route
+	rules           []rule            // rules which apply to every route
 	fallbackType    string
 	FallbackContent []byte
+
+	// pattern of route and wildcards captured from path are forwarded to backends in these
+	// headers, not forwarded if they're empty
+	routeHeader       string
+	paramHeaderPrefix []byte
+
+	// lock serializes changes made through admin API, and config is the configuration
+	// the application built from, it's nil if the application is not built from config.
+	lock      sync.Mutex
+	config    *appConfig
+	detector  *outlierDetector
+	slowStart *slowStartConfig
+
+	// split is how requests are split between groups of backends, it's a *groupSplit and
+	// stores nothing if the application has no group
+	split  atomic.Value
+	canary *canaryAnalyzer // nil if canary analysis is disabled
+
+	// stop is closed when the application is closed, goroutines of it should quit
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
 // NewApp return a brand new Application
 func NewApp(b Balancer, tsr bool) *Application {
-	return &Application{tsr, b, &node{}, "", []byte("")}
+	a := &Application{
+		TSRRedirect: tsr, balancer: b, routes: map[string]*route{}, FallbackContent: []byte(""),
+		stop: make(chan struct{}),
+	}
+	a.root.Store(&node{})
+
+	return a
 }
 
-func convertMethod(methods ...string) HTTPMethod {
-	httpMethods := NONE
-
-	if len(methods) == 0 {
-		log.Panicf("at least one method is required")
-	}
-
-	for _, m := range methods {
-		switch m {
-		case "GET":
-			httpMethods |= GET
-		case "POST":
-			httpMethods |= POST
-		case "PUT":
-			httpMethods |= PUT
-		case "DELETE":
-			httpMethods |= DELETE
-		case "HEAD":
-			httpMethods |= HEAD
-		case "OPTIONS":
-			httpMethods |= OPTIONS
-		case "CONNECT":
-			httpMethods |= CONNECT
-		case "TRACE":
-			httpMethods |= TRACE
-		case "PATCH":
-			httpMethods |= PATCH
-		default:
-			log.Panicf("bad http method: %s", m)
-		}
-	}
+// tree return the radix tree which is serving
+func (a *Application) tree() *node {
+	n, _ := a.root.Load().(*node)
+	return n
+}
 
-	return httpMethods
+// Close stop goroutines of the application, e.g. discoverers
+func (a *Application) Close() {
+	a.stopOnce.Do(func() { close(a.stop) })
 }
 
-// AddRoute add a route to itself
-func (a *Application) AddRoute(path string, methods ...string) {
-	a.root.addRoute([]byte(path), convertMethod(methods...))
+// AddRoute add a route to itself, the tree is unchanged if the path or methods are bad
+func (a *Application) AddRoute(path string, methods ...string) error {
+	httpMethods, err := convertMethod(methods...)
+	if err != nil {
+		return &pathError{path: path, err: err, detail: strings.Join(methods, ",")}
+	}
+
+	root := a.tree().clone()
+	leaf, err := root.addRoute([]byte(path), httpMethods)
+	if err != nil {
+		return err
+	}
+	a.root.Store(root)
+
+	if leaf.route != nil {
+		a.routes[path] = leaf.route
+	}
+	return nil
 }
 
-func (a *Application) ServeHTTP(ctx *fasthttp.RequestCtx) {
-	if a.root == nil {
-		log.Panic("application should bind a URL-tree")
+// redirectCode return 301 for GET, and 307 for others so the method is kept
+func redirectCode(method HTTPMethod) int {
+	if method != GET {
+		return fasthttp.StatusTemporaryRedirect
 	}
-	if a.balancer == nil {
-		log.Panic("application should bind a load balancer")
+	return fasthttp.StatusMovedPermanently
+}
+
+// resolution is how a request is served, the request is proxied if status is 0
+type resolution struct {
+	status   int
+	n        *node  // the route which matches, nil if none does
+	tsr      bool   // a route matches if trailing slash is added or removed
+	fixed    []byte // the fixed path which is matched
+	redirect []byte // where the request is redirected to
+	allow    HTTPMethod
+	ratio    float64 // ratio of failures of the route
+	group    *backendGroup
+	balancer Balancer // the balancer which the request is proxied by
+}
+
+// resolve how the application would serve the request, nothing is written to ctx, and no
+// state is changed. paths redirected to are appended to *bufp. both ServeHTTP and explain
+// resolve requests by it, so they never disagree
+func (a *Application) resolve(ctx *fasthttp.RequestCtx, ps *params, bufp *[]byte) (res resolution) {
+	// methods which are not registered are not implemented for any route
+	method, known := methodOf(ctx.Method())
+	if !known {
+		res.status = fasthttp.StatusNotImplemented
+		return res
 	}
 
+	root := a.tree()
 	path := ctx.Path()
-	n, tsr, found := a.root.byPath(path)
+	n, tsr, found := root.lookup(path, ps)
+	res.tsr = tsr
 
 	// redirect?
 	if tsr && a.TSRRedirect {
-		code := fasthttp.StatusMovedPermanently
-		if string(ctx.Method()) != "GET" {
-			code = fasthttp.StatusTemporaryRedirect
-		}
-
-		var redirectTo []byte
+		buf := append(*bufp, path...)
 		if len(path) > 1 && path[len(path)-1] == '/' {
-			redirectTo = path[:len(path)-1]
+			buf = buf[:len(buf)-1]
 		} else {
-			redirectTo = append(path, '/')
+			buf = append(buf, '/')
+		}
+		*bufp = buf
+		res.status, res.redirect = redirectCode(method), buf
+		return res
+	}
+
+	// fix the path, e.g. `/User//Profile/../jhon` is `/user/jhon`
+	if !found && a.fixPath != "" {
+		fixed, ok := root.fixPath(path, a.TSRRedirect, *bufp)
+		*bufp = fixed
+		if ok && a.fixPath == fixPathRedirect {
+			if query := ctx.URI().QueryString(); len(query) > 0 {
+				fixed = append(append(fixed, '?'), query...)
+				*bufp = fixed
+			}
+			res.status, res.redirect = redirectCode(method), fixed
+			return res
+		}
+		if ok {
+			res.fixed = fixed
+			n, _, found = root.lookup(fixed, ps)
 		}
-		log.Printf("redirect to %s", redirectTo)
-		ctx.RedirectBytes(redirectTo, code)
-		return
 	}
 
 	// not found
 	if !found {
-		ctx.NotFound()
-		return
+		if a.defaultRoute == nil {
+			res.status = fasthttp.StatusNotFound
+			return res
+		}
+		n = a.defaultRoute
+	}
+	res.n, res.allow = n, n.methods
+	if a.options != nil {
+		res.allow |= OPTIONS
+	}
+
+	// answer OPTIONS by guard if the route doesn't allow it
+	if method == OPTIONS && a.options != nil && !n.hasMethod(OPTIONS) {
+		res.status = fasthttp.StatusNoContent
+		return res
 	}
 
 	// method allowed?
-	if !n.hasMethod(convertMethod(string(ctx.Method()))) {
-		ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
-		return
+	if !n.hasMethod(method) {
+		res.status = fasthttp.StatusMethodNotAllowed
+		return res
 	}
 
 	// circuit breaker is open?
-	_, _, _, _, ratio := n.query()
-	if ratio > 0.3 {
+	if _, _, _, _, res.ratio = n.query(); res.ratio > openRatio {
+		res.status = fasthttp.StatusTooManyRequests
+		return res
+	}
+
+	// rules of the route take precedence over pinning and percentage, and routes with their
+	// own backends are not split between groups
+	r := n.route
+	if split := a.groupSplit(); split != nil {
+		res.group = r.match(ctx)
+		if res.group == nil && r.ownBalancer() == nil {
+			res.group = split.pick(ctx)
+		}
+	}
+	switch {
+	case res.group != nil:
+		res.balancer = res.group.balancer
+	case r.ownBalancer() != nil:
+		res.balancer = r.ownBalancer()
+	default:
+		res.balancer = a.balancer
+	}
+
+	return res
+}
+
+// redirect the request to path with code
+func redirect(ctx *fasthttp.RequestCtx, path []byte, code int) {
+	log.Printf("redirect to %s", path)
+	ctx.RedirectBytes(path, code)
+}
+
+func (a *Application) ServeHTTP(ctx *fasthttp.RequestCtx) {
+	if a.tree() == nil {
+		log.Panic("application should bind a URL-tree")
+	}
+	if a.balancer == nil {
+		log.Panic("application should bind a load balancer")
+	}
+
+	ps := acquireParams()
+	defer releaseParams(ps)
+	bufp := acquireBuf()
+	defer releaseBuf(bufp)
+
+	res := a.resolve(ctx, ps, bufp)
+	switch {
+	case res.redirect != nil:
+		redirect(ctx, res.redirect, res.status)
+		return
+	case res.status == fasthttp.StatusNotFound:
+		ctx.NotFound()
+		return
+	case res.status == fasthttp.StatusNoContent:
+		a.options.answer(ctx, res.n.methods)
+		return
+	case res.status == fasthttp.StatusMethodNotAllowed:
+		setAllow(ctx, res.allow)
+		ctx.SetStatusCode(res.status)
+		return
+	case res.status == fasthttp.StatusTooManyRequests:
 		// fallback
-		log.Printf("too many requests, ratio is %f", ratio)
+		log.Printf("too many requests, ratio is %f", res.ratio)
 		switch a.fallbackType {
 		case fallbackJSON:
 			ctx.SetContentType("application/json")
@@ -120,10 +274,24 @@ func (a *Application) ServeHTTP(ctx *fasthttp.RequestCtx) {
 		}
 		ctx.SetStatusCode(fasthttp.StatusTooManyRequests)
 		ctx.Write(a.FallbackContent)
+		return
+	case res.status != 0:
+		ctx.SetStatusCode(res.status)
+		return
+	}
 
+	// proxy! and then feedback the result. the group is picked before the path is rewritten,
+	// so rules see the request as it's sent
+	n, r := res.n, res.n.route
+	r.forward(ctx, *ps, a.routeHeader, a.paramHeaderPrefix)
+	r.rewritePath(ctx, *ps)
+	if g := res.group; g != nil {
+		start := time.Now()
+		code := Proxy(g.balancer, ctx, r.proxyTimeout())
+		g.observe(code, time.Since(start))
+		n.incr(code)
 		return
 	}
 
-	// proxy! and then feedback the result
-	n.incr(Proxy(a.balancer, ctx))
+	n.incr(Proxy(res.balancer, ctx, r.proxyTimeout()))
 }
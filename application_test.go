@@ -14,26 +14,36 @@ func TestAddRouteWorks(t *testing.T) {
 }
 
 func TestAddRouteBadMethod(t *testing.T) {
-	defer shouldPanic()
-
 	a := NewApp(NewRdm(), true)
 
-	a.AddRoute("/user/:name", "BABY")
+	err := a.AddRoute("/user/:name", "BABY")
+	shouldBePathError(t, err, "/user/:name", errBadMethod)
 }
 
 func TestAddRouteNoMethod(t *testing.T) {
-	defer shouldPanic()
+	a := NewApp(NewRdm(), true)
 
+	err := a.AddRoute("/user/:name")
+	shouldBePathError(t, err, "/user/:name", errMethodEmpty)
+}
+
+func TestAddRouteBadPath(t *testing.T) {
 	a := NewApp(NewRdm(), true)
+	a.AddRoute("/user/:name", "GET")
+	root := a.tree()
 
-	a.AddRoute("/user/:name")
+	err := a.AddRoute("/user/:id/card", "GET")
+	shouldBePathError(t, err, "/user/:id/card", errWildcardConflict)
+	if a.tree() != root || len(a.routes) != 1 {
+		t.Errorf("routes should be unchanged")
+	}
 }
 
 func TestApplicationNilTree(t *testing.T) {
 	defer shouldPanic()
 
 	a := NewApp(NewRdm(), true)
-	a.root = nil
+	a.root.Store((*node)(nil))
 
 	ctx := &fasthttp.RequestCtx{}
 	ctx.Request.SetRequestURI("/")
@@ -131,7 +141,7 @@ func TestApplicationCircuit(t *testing.T) {
 
 	// circuit is on
 	for i := 0; i < 100; i++ {
-		a.root.incr(fasthttp.StatusBadGateway)
+		a.tree().incr(fasthttp.StatusBadGateway)
 	}
 	a.ServeHTTP(ctx)
 	if code := ctx.Response.StatusCode(); code != fasthttp.StatusTooManyRequests {
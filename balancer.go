@@ -1,6 +1,9 @@
 package main
 
 import (
+	"sync/atomic"
+	"time"
+
 	"github.com/valyala/fasthttp"
 )
 
@@ -16,6 +19,10 @@ type Backend struct {
 	Weight int
 	URL    string // cache the result
 	client *fasthttp.HostClient
+	stats  *backendStats // shared by all copies of the backend
+	Backup bool          // backup backends are used only when primary ones are unavailable
+	source string        // which discoverer the backend comes from, empty if it's in configuration
+	Zone   string        // e.g. rack or availability zone, used by zone-aware balancer
 }
 
 // NewBackend return a new backend
@@ -23,11 +30,79 @@ func NewBackend(url string, weight int) Backend {
 	return Backend{
 		weight, url,
 		&fasthttp.HostClient{Addr: url, MaxConns: fasthttp.DefaultMaxConnsPerHost * 4},
+		&backendStats{url: url}, false, "", "",
+	}
+}
+
+// available return false if the backend should not be selected, e.g. it's ejected or draining
+func (b *Backend) available(now int64) bool {
+	return b.stats == nil || !b.stats.isEjected(now) && atomic.LoadUint32(&b.stats.draining) == 0
+}
+
+// acquire should be called before a request is proxied to the backend, and release after
+func (b *Backend) acquire() {
+	if b.stats != nil {
+		atomic.AddInt64(&b.stats.inflight, 1)
+	}
+}
+
+func (b *Backend) release() {
+	if b.stats != nil {
+		atomic.AddInt64(&b.stats.inflight, -1)
+	}
+}
+
+// report feedback the response of a request which is proxied to the backend
+func (b *Backend) report(code int, latency time.Duration) {
+	if b.stats != nil {
+		b.stats.report(code, latency)
 	}
 }
 
 // Balancer should have a method `Select`, which return the backend we should
-// proxy.
+// proxy. backends of a balancer can be replaced by `Update` while it's serving,
+// and the slice returned by `Backends` should never be modified.
+// backup backends are selected only if none of the primary ones is available,
+// `Failovers` return how many times it happens.
 type Balancer interface {
 	Select() (*Backend, bool)
+	Backends() []Backend
+	Update(backends ...Backend)
+	Failovers() uint64
+}
+
+// upstream holds backends of a balancer, primary and backup ones are kept separate.
+// it's never modified after created, balancer replace it as a whole.
+type upstream struct {
+	all     []Backend
+	primary []Backend
+	backup  []Backend
+}
+
+func newUpstream(backends []Backend) *upstream {
+	u := &upstream{all: backends}
+
+	for _, b := range backends {
+		if b.Backup {
+			u.backup = append(u.backup, b)
+		} else {
+			u.primary = append(u.primary, b)
+		}
+	}
+
+	return u
+}
+
+// failoverCounter counts how many requests are proxied to backup backends
+type failoverCounter struct {
+	failovers uint64
+}
+
+func (c *failoverCounter) failover() {
+	atomic.AddUint64(&c.failovers, 1)
+}
+
+// Failovers return how many requests are proxied to backup backends
+func (c *failoverCounter) Failovers() uint64 {
+	return atomic.LoadUint64(&c.failovers)
 }
@@ -2,26 +2,79 @@ package main
 
 import (
 	"math/rand"
+	"sync/atomic"
 )
 
 // Rdm is struct for random balance algorithm
 type Rdm struct {
-	upstream []Backend
+	failoverCounter
+
+	upstream atomic.Value // *upstream, it's replaced as a whole when updated
 }
 
 // NewRdm return a brand new random balancer
 func NewRdm(backends ...Backend) *Rdm {
-	return &Rdm{backends}
+	r := &Rdm{}
+	r.Update(backends...)
+	return r
+}
+
+// Backends return backends of the balancer, it should be treated as read only
+func (r *Rdm) Backends() []Backend {
+	return r.upstream.Load().(*upstream).all
+}
+
+// Update replace backends of the balancer
+func (r *Rdm) Update(backends ...Backend) {
+	r.upstream.Store(newUpstream(backends))
 }
 
-// Select return a backend randomly
-func (r *Rdm) Select() (*Backend, bool) {
-	length := len(r.upstream)
+// Select return a backend randomly, backup backends are used only if none of
+// the primary ones is available
+func (r *Rdm) Select() (b *Backend, found bool) {
+	u := r.upstream.Load().(*upstream)
+	now := CoarseTimeNow().Unix()
+
+	if b, found = r.selectFrom(u.primary, now); found {
+		return b, found
+	}
+
+	if b, found = r.selectFrom(u.backup, now); found {
+		r.failover()
+	}
+
+	return b, found
+}
+
+func (r *Rdm) selectFrom(upstream []Backend, now int64) (*Backend, bool) {
+	length := len(upstream)
 	if length == 0 {
 		return nil, false
-	} else if length == 1 {
-		return &r.upstream[0], true
 	}
 
-	return &(r.upstream[rand.Int()%length]), true
+	if length == 1 {
+		if b := &upstream[0]; b.available(now) {
+			return b, true
+		}
+		return nil, false
+	}
+
+	// start from a random backend, and look for the first available one, backends in slow
+	// start window may be skipped, but if nothing else is available, they are returned
+	var fallback *Backend
+	start := rand.Int()
+	for i := 0; i < length; i++ {
+		b := &(upstream[(start+i)%length])
+		if !b.available(now) {
+			continue
+		}
+		if b.admit(now) {
+			return b, true
+		}
+		if fallback == nil {
+			fallback = b
+		}
+	}
+
+	return fallback, fallback != nil
 }
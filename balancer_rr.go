@@ -6,24 +6,76 @@ import (
 
 // RR is struct for naive round robin balance algorithm
 type RR struct {
-	upstream []Backend
-	index    uint64
+	failoverCounter
+
+	upstream    atomic.Value // *upstream, it's replaced as a whole when updated
+	index       uint64
+	backupIndex uint64
 }
 
 // NewRR return a brand new naive round robin balancer
 func NewRR(backends ...Backend) *RR {
-	return &RR{backends, 0}
+	r := &RR{}
+	r.Update(backends...)
+	return r
+}
+
+// Backends return backends of the balancer, it should be treated as read only
+func (r *RR) Backends() []Backend {
+	return r.upstream.Load().(*upstream).all
+}
+
+// Update replace backends of the balancer
+func (r *RR) Update(backends ...Backend) {
+	r.upstream.Store(newUpstream(backends))
 }
 
-// Select return a backend randomly
+// Select return the next available backend, backup backends are used only if
+// none of the primary ones is available
 func (r *RR) Select() (b *Backend, found bool) {
-	length := uint64(len(r.upstream))
+	u := r.upstream.Load().(*upstream)
+	now := CoarseTimeNow().Unix()
+
+	if b, found = r.selectFrom(u.primary, &r.index, now); found {
+		return b, found
+	}
+
+	if b, found = r.selectFrom(u.backup, &r.backupIndex, now); found {
+		r.failover()
+	}
+
+	return b, found
+}
+
+func (r *RR) selectFrom(upstream []Backend, index *uint64, now int64) (b *Backend, found bool) {
+	length := uint64(len(upstream))
 	if length == 0 {
 		return nil, false
-	} else if length == 1 {
-		return &r.upstream[0], true
+	}
+
+	if length == 1 {
+		if b = &upstream[0]; b.available(now) {
+			return b, true
+		}
+		return nil, false
 	}
 
 	// TODO: shuold we check for overflow?
-	return &(r.upstream[atomic.AddUint64(&r.index, 1)%length]), true
+	// backends in slow start window may be skipped, but if nothing else is
+	// available, the first available one is returned
+	var fallback *Backend
+	for i := uint64(0); i < length; i++ {
+		b = &(upstream[atomic.AddUint64(index, 1)%length])
+		if !b.available(now) {
+			continue
+		}
+		if b.admit(now) {
+			return b, true
+		}
+		if fallback == nil {
+			fallback = b
+		}
+	}
+
+	return fallback, fallback != nil
 }
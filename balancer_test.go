@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestBackupBackends(t *testing.T) {
+	b1 := NewBackend("192.168.1.1:80", 5)
+	b2 := NewBackend("192.168.1.2:80", 1)
+	backup := NewBackend("192.168.1.3:80", 1)
+	backup.Backup = true
+
+	for _, balancer := range []Balancer{NewWRR(b1, backup, b2), NewRR(b1, backup, b2), NewRdm(b1, backup, b2)} {
+		a := NewApp(balancer, true)
+
+		if backends := balancer.Backends(); len(backends) != 3 || backends[1].URL != backup.URL {
+			t.Errorf("%T should keep the order of backends, but got: %+v", balancer, backends)
+		}
+
+		for i := 0; i < 10; i++ {
+			if b, found := balancer.Select(); !found || b.Backup {
+				t.Errorf("%T should not select backup backend, but got: %+v, %t", balancer, b, found)
+			}
+		}
+		if status := a.Status(); status.Failovers != 0 || status.FailedOver {
+			t.Errorf("%T should not fail over, but got: %+v", balancer, status)
+		}
+
+		a.Drain(b1.URL, true)
+		a.Drain(b2.URL, true)
+		for i := 0; i < 10; i++ {
+			if b, found := balancer.Select(); !found || !b.Backup {
+				t.Errorf("%T should select backup backend, but got: %+v, %t", balancer, b, found)
+			}
+		}
+		if status := a.Status(); status.Failovers != 10 || !status.FailedOver {
+			t.Errorf("%T should fail over, but got: %+v", balancer, status)
+		}
+
+		a.Drain(backup.URL, true)
+		if _, found := balancer.Select(); found {
+			t.Errorf("%T should not found any backend", balancer)
+		}
+
+		a.Drain(b1.URL, false)
+		a.Drain(b2.URL, false)
+		a.Drain(backup.URL, false)
+	}
+}
@@ -7,46 +7,125 @@ import (
 // WRR is weighted round robin algorithm, it's borrowed from Nginx:
 // https://github.com/nginx/nginx/commit/52327e0627f49dbda1e8db695e63a4b0af4448b1
 type WRR struct {
+	failoverCounter
 	lock sync.Mutex
 
-	upstream    []Backend
-	totalWeight int
-	weights     []int
+	upstream      *upstream
+	weights       []int // current weights of primary backends
+	backupWeights []int // current weights of backup backends
 }
 
-// NewWRR return a instance with initialized weights & totalWeight
+// NewWRR return a instance with initialized weights
 func NewWRR(backends ...Backend) *WRR {
-	totalWeight := 0
+	w := &WRR{upstream: &upstream{}}
+	w.Update(backends...)
+	return w
+}
+
+// Backends return backends of the balancer, it should be treated as read only
+func (w *WRR) Backends() []Backend {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return w.upstream.all
+}
+
+// keepWeights return current weights of backends, weights of the backends which are
+// in old ones are kept.
+func keepWeights(backends []Backend, old []Backend, oldWeights []int) []int {
 	weights := make([]int, len(backends))
 
-	for _, b := range backends {
-		totalWeight += b.Weight
+	for i, b := range backends {
+		for j, o := range old {
+			if b.stats != nil && b.stats == o.stats {
+				weights[i] = oldWeights[j]
+				break
+			}
+		}
 	}
 
-	return &WRR{upstream: backends, totalWeight: totalWeight, weights: weights}
+	return weights
+}
+
+// Update replace backends of the balancer, current weights of the backends which
+// are still in the balancer are kept.
+func (w *WRR) Update(backends ...Backend) {
+	u := newUpstream(backends)
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.weights = keepWeights(u.primary, w.upstream.primary, w.weights)
+	w.backupWeights = keepWeights(u.backup, w.upstream.backup, w.backupWeights)
+	w.upstream = u
 }
 
 // Select return the backend we should proxy
 // for example, weights of [5, 1, 1] should generate sequence of index:
 // [1, 1, 2, 1, 3, 1, 1]
+// backup backends are used only if none of the primary ones is available.
 func (w *WRR) Select() (b *Backend, found bool) {
-	length := uint64(len(w.upstream))
+	now := CoarseTimeNow().Unix()
+
+	w.lock.Lock()
+
+	b = w.selectFrom(w.upstream.primary, w.weights, now)
+	if b == nil {
+		if b = w.selectFrom(w.upstream.backup, w.backupWeights, now); b != nil {
+			w.failover()
+		}
+	}
+
+	// defer is too slow...
+	w.lock.Unlock()
+
+	return b, b != nil
+}
+
+// selectFrom should be called with w.lock held
+func (w *WRR) selectFrom(upstream []Backend, weights []int, now int64) *Backend {
+	length := len(upstream)
 	if length == 0 {
-		return nil, false
-	} else if length == 1 {
-		return &w.upstream[0], true
+		return nil
 	}
 
-	w.lock.Lock()
+	if length == 1 {
+		if b := &upstream[0]; b.available(now) {
+			return b
+		}
+		return nil
+	}
 
-	totalWeight := w.totalWeight
-	upstream := w.upstream
-	weights := w.weights
+	// backends in slow start window may be skipped, try again, but if nothing else is
+	// available, the last one is returned
+	biggest := -1
+	for i := 0; i < length; i++ {
+		if biggest = wrrNext(upstream, weights, now); biggest < 0 || upstream[biggest].admit(now) {
+			break
+		}
+	}
+
+	if biggest < 0 {
+		return nil
+	}
+
+	return &upstream[biggest]
+}
+
+// wrrNext return index of the next backend
+func wrrNext(upstream []Backend, weights []int, now int64) int {
+	// unavailable backends are skipped, just like what nginx does for down peers
+	totalWeight := 0
 	biggest := -1
 	biggestWeight := 0
 
 	for i := range weights {
+		if !upstream[i].available(now) {
+			continue
+		}
+
 		weights[i] += upstream[i].Weight
+		totalWeight += upstream[i].Weight
 
 		if weights[i] > biggestWeight {
 			biggestWeight = weights[i]
@@ -54,17 +133,9 @@ func (w *WRR) Select() (b *Backend, found bool) {
 		}
 	}
 
-	if biggest >= 0 && biggest < len(weights) {
+	if biggest >= 0 {
 		weights[biggest] -= totalWeight
-
-		// defer is too slow...
-		w.lock.Unlock()
-
-		return &w.upstream[biggest], true
 	}
 
-	// defer is too slow...
-	w.lock.Unlock()
-
-	return nil, false
+	return biggest
 }
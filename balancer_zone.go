@@ -0,0 +1,220 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+zone-aware load balancing, every backend may be labeled with a zone, and guard knows which
+zone it's in by `-zone`. requests are proxied to backends in the local zone while enough of
+them are healthy, and spill over to other zones in proportion when they're not.
+*/
+
+const (
+	defaultMinHealthyPercent = 70
+)
+
+type zoneAwareConfig struct {
+	// all requests stay in the local zone if at least this percent of its capacity is healthy,
+	// otherwise the local zone receives a share in proportion to its healthy capacity
+	MinHealthyPercent int `json:"min_healthy_percent"`
+}
+
+func checkZoneAwareConfig(c *zoneAwareConfig) error {
+	if c.MinHealthyPercent < 0 || c.MinHealthyPercent > 100 {
+		return errBadMinHealthyPercent
+	}
+	if c.MinHealthyPercent == 0 {
+		c.MinHealthyPercent = defaultMinHealthyPercent
+	}
+
+	return nil
+}
+
+// zoneGroup is primary backends in the same zone, and the balancer of them
+type zoneGroup struct {
+	name     string
+	balancer Balancer
+	backends []Backend
+}
+
+// zonedUpstream is never modified after created, just like upstream
+type zonedUpstream struct {
+	all   []Backend
+	zones []*zoneGroup
+	local *zoneGroup // nil if none of backends is in the local zone
+}
+
+// Zoned wraps balancers of every zone, it selects a zone first, and then let balancer of the
+// zone select a backend. backup backends are used only if none of primary ones in any zone is
+// available.
+type Zoned struct {
+	failoverCounter
+
+	localZone   string
+	minHealthy  float64
+	newBalancer func(backends ...Backend) Balancer
+
+	lock      sync.Mutex // serializes Update
+	balancers map[string]Balancer
+	backup    Balancer
+	upstream  atomic.Value // *zonedUpstream
+
+	localRequests     uint64
+	crossZoneRequests uint64
+}
+
+// NewZoned return a zone-aware balancer, balancers of zones are created by newBalancer
+func NewZoned(localZone string, minHealthyPercent int, newBalancer func(backends ...Backend) Balancer, backends ...Backend) *Zoned {
+	z := &Zoned{
+		localZone:   localZone,
+		minHealthy:  float64(minHealthyPercent) / 100,
+		newBalancer: newBalancer,
+		balancers:   map[string]Balancer{},
+		backup:      newBalancer(),
+	}
+	z.Update(backends...)
+	return z
+}
+
+// Backends return backends of the balancer, it should be treated as read only
+func (z *Zoned) Backends() []Backend {
+	return z.upstream.Load().(*zonedUpstream).all
+}
+
+// Update replace backends of the balancer, balancers of zones which are still there are
+// updated instead of rebuilt, so their states are kept
+func (z *Zoned) Update(backends ...Backend) {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+
+	names := []string{}
+	groups := map[string][]Backend{}
+	backups := []Backend{}
+	for _, b := range backends {
+		if b.Backup {
+			backups = append(backups, b)
+			continue
+		}
+		if _, exist := groups[b.Zone]; !exist {
+			names = append(names, b.Zone)
+		}
+		groups[b.Zone] = append(groups[b.Zone], b)
+	}
+
+	u := &zonedUpstream{all: backends}
+	balancers := make(map[string]Balancer, len(names))
+	for _, name := range names {
+		balancer, exist := z.balancers[name]
+		if exist {
+			balancer.Update(groups[name]...)
+		} else {
+			balancer = z.newBalancer(groups[name]...)
+		}
+		balancers[name] = balancer
+
+		group := &zoneGroup{name, balancer, balancer.Backends()}
+		u.zones = append(u.zones, group)
+		if name != "" && name == z.localZone {
+			u.local = group
+		}
+	}
+
+	z.balancers = balancers
+	z.backup.Update(backups...)
+	z.upstream.Store(u)
+}
+
+// capacity return sum of weights of available backends, and of all backends
+func capacity(backends []Backend, now int64) (healthy int, total int) {
+	for i := range backends {
+		total += backends[i].Weight
+		if backends[i].available(now) {
+			healthy += backends[i].Weight
+		}
+	}
+
+	return healthy, total
+}
+
+// Select return a backend in the local zone if it's healthy enough, otherwise a backend
+// in a zone selected by healthy capacity
+func (z *Zoned) Select() (b *Backend, found bool) {
+	u := z.upstream.Load().(*zonedUpstream)
+	now := CoarseTimeNow().Unix()
+
+	if b, found = z.selectPrimary(u, now); found {
+		return b, found
+	}
+
+	if b, found = z.backup.Select(); found {
+		z.failover()
+	}
+
+	return b, found
+}
+
+func (z *Zoned) selectPrimary(u *zonedUpstream, now int64) (*Backend, bool) {
+	if u.local != nil {
+		healthy, total := capacity(u.local.backends, now)
+
+		share := 1.0
+		if total > 0 && z.minHealthy > 0 {
+			share = float64(healthy) / float64(total) / z.minHealthy
+		}
+		if share >= 1 || rand.Float64() < share {
+			if b, found := u.local.balancer.Select(); found {
+				atomic.AddUint64(&z.localRequests, 1)
+				return b, found
+			}
+		}
+	}
+
+	// spill over to other zones in proportion to their healthy capacity
+	remoteHealthy := 0
+	for _, zone := range u.zones {
+		if zone != u.local {
+			healthy, _ := capacity(zone.backends, now)
+			remoteHealthy += healthy
+		}
+	}
+	if remoteHealthy > 0 {
+		pick := rand.Intn(remoteHealthy)
+		for _, zone := range u.zones {
+			if zone == u.local {
+				continue
+			}
+			if healthy, _ := capacity(zone.backends, now); pick >= healthy {
+				pick -= healthy
+				continue
+			}
+			if b, found := zone.balancer.Select(); found {
+				atomic.AddUint64(&z.crossZoneRequests, 1)
+				return b, found
+			}
+			break
+		}
+	}
+
+	// e.g. backends with weight 0, or backends in slow start window
+	for _, zone := range u.zones {
+		if b, found := zone.balancer.Select(); found {
+			if zone == u.local {
+				atomic.AddUint64(&z.localRequests, 1)
+			} else {
+				atomic.AddUint64(&z.crossZoneRequests, 1)
+			}
+			return b, found
+		}
+	}
+
+	return nil, false
+}
+
+// Requests return how many requests are proxied to primary backends in the local zone, and
+// in other zones
+func (z *Zoned) Requests() (local uint64, crossZone uint64) {
+	return atomic.LoadUint64(&z.localRequests), atomic.LoadUint64(&z.crossZoneRequests)
+}
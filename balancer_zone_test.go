@@ -0,0 +1,138 @@
+package main
+
+import (
+	"testing"
+)
+
+func zonedBackend(url string, zone string) Backend {
+	b := NewBackend(url, 1)
+	b.Zone = zone
+	return b
+}
+
+func TestCheckZoneAwareConfig(t *testing.T) {
+	c := &zoneAwareConfig{}
+	if err := checkZoneAwareConfig(c); err != nil || c.MinHealthyPercent != defaultMinHealthyPercent {
+		t.Errorf("should set default min healthy percent, but got: %+v, %s", c, err)
+	}
+	if err := checkZoneAwareConfig(&zoneAwareConfig{MinHealthyPercent: 101}); err != errBadMinHealthyPercent {
+		t.Errorf("should return %s but got: %s", errBadMinHealthyPercent, err)
+	}
+}
+
+func TestZoned(t *testing.T) {
+	a1 := zonedBackend("192.168.1.1:80", "a")
+	a2 := zonedBackend("192.168.1.2:80", "a")
+	b1 := zonedBackend("192.168.2.1:80", "b")
+	c1 := zonedBackend("192.168.3.1:80", "c")
+	c2 := zonedBackend("192.168.3.2:80", "c")
+	c3 := zonedBackend("192.168.3.3:80", "c")
+	backup := zonedBackend("192.168.9.1:80", "a")
+	backup.Backup = true
+
+	for _, method := range []string{LBMWRR, LBMRR, LBMRandom} {
+		newBalancer := func(backends ...Backend) Balancer { return getBalancer(method, backends...) }
+		z := NewZoned("a", 70, newBalancer, a1, a2, b1, c1, c2, c3, backup)
+
+		count := func() map[string]int {
+			counts := map[string]int{}
+			for i := 0; i < 2000; i++ {
+				b, found := z.Select()
+				if !found {
+					t.Fatalf("%s: should select a backend", method)
+				}
+				counts[b.Zone]++
+				if b.Backup {
+					counts["backup"]++
+				}
+			}
+			return counts
+		}
+
+		// all requests stay in the local zone
+		if counts := count(); counts["a"] != 2000 {
+			t.Errorf("%s: all requests should stay in local zone, but got: %+v", method, counts)
+		}
+
+		// half of local zone is healthy, about 0.5 / 0.7 of requests stay
+		a1.stats.ejectedUntil = CoarseTimeNow().Unix() + 100
+		if counts := count(); counts["a"] < 1200 || counts["a"] > 1650 || counts["b"] == 0 || counts["c"] < counts["b"] {
+			t.Errorf("%s: requests should spill over in proportion, but got: %+v", method, counts)
+		}
+
+		// local zone is down, requests go to other zones by their healthy capacity
+		a2.stats.ejectedUntil = CoarseTimeNow().Unix() + 100
+		if counts := count(); counts["a"] != 0 || counts["b"] < 300 || counts["b"] > 700 {
+			t.Errorf("%s: requests should spill over to other zones, but got: %+v", method, counts)
+		}
+
+		// backup backends are used only if all zones are down
+		for _, b := range []Backend{b1, c1, c2, c3} {
+			b.stats.ejectedUntil = CoarseTimeNow().Unix() + 100
+		}
+		if counts := count(); counts["backup"] != 2000 || z.Failovers() != 2000 {
+			t.Errorf("%s: backup backends should be used, but got: %+v, %d", method, counts, z.Failovers())
+		}
+
+		local, crossZone := z.Requests()
+		if local+crossZone != 6000 || local < 3200 || crossZone < 2000 {
+			t.Errorf("%s: local and cross zone requests are wrong: %d, %d", method, local, crossZone)
+		}
+
+		for _, b := range []Backend{a1, a2, b1, c1, c2, c3} {
+			b.stats.ejectedUntil = 0
+		}
+	}
+}
+
+func TestZonedUpdate(t *testing.T) {
+	a1 := zonedBackend("192.168.1.1:80", "a")
+	b1 := zonedBackend("192.168.2.1:80", "b")
+	z := NewZoned("a", 70, func(backends ...Backend) Balancer { return NewWRR(backends...) }, a1, b1)
+
+	balancer := z.balancers["a"]
+	a2 := zonedBackend("192.168.1.2:80", "a")
+	z.Update(a1, a2)
+
+	if z.balancers["a"] != balancer || len(z.balancers) != 1 {
+		t.Errorf("balancer of zone should be kept, but got: %+v", z.balancers)
+	}
+	if backends := z.Backends(); len(backends) != 2 || len(balancer.Backends()) != 2 {
+		t.Errorf("backends should be updated, but got: %+v", backends)
+	}
+	for i := 0; i < 10; i++ {
+		if b, found := z.Select(); !found || b.Zone != "a" {
+			t.Errorf("should select backend in local zone, but got: %+v, %t", b, found)
+		}
+	}
+
+	// zone-aware status of app
+	a := NewApp(z, true)
+	if status := a.Status(); status.Zone != "a" || status.LocalRequests != 10 || status.CrossZoneRatio != 0 {
+		t.Errorf("status of app is wrong: %+v", status)
+	}
+}
+
+func TestZoneConfig(t *testing.T) {
+	config := &appConfig{
+		Name: "zone.example.com", LoadBalanceMethod: LBMRR,
+		Backends: []string{"192.168.1.1:80", "192.168.2.1:80"}, Weights: []int{1, 1}, Zones: []string{"a", "b"},
+		ZoneAware: &zoneAwareConfig{},
+	}
+	if err := checkAppConfig(config); err != nil {
+		t.Fatalf("should not return error but got: %s", err)
+	}
+
+	a, _ := getAPP(config)
+	if backends := a.balancer.Backends(); backends[0].Zone != "a" || backends[1].Zone != "b" {
+		t.Errorf("zone of backends are wrong: %+v", backends)
+	}
+	if _, ok := a.balancer.(*Zoned); !ok {
+		t.Errorf("balancer should be zone-aware, but got: %T", a.balancer)
+	}
+
+	config.Zones = []string{"a"}
+	if err := checkAppConfig(config); err != errBackendZoneNotMatch {
+		t.Errorf("should return %s but got: %s", errBackendZoneNotMatch, err)
+	}
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"sync"
+	"sync/atomic"
+
 	"github.com/valyala/fasthttp"
 )
 
@@ -11,22 +14,27 @@ status.
 
 // Breaker is circuit breaker, it's a collection of Application
 type Breaker struct {
-	apps map[string]*Application
+	apps map[string]*Application // name -> app
+
+	// lock serializes setting apps, and hosts is the *hostTable built from apps, requests are
+	// served by the app which their host matches
+	lock  sync.Mutex
+	hosts atomic.Value
 }
 
 // NewBreaker return a brand new circuit breaker, with nothing in mapper
 func NewBreaker() *Breaker {
 	return &Breaker{
-		make(map[string]*Application),
+		apps: make(map[string]*Application),
 	}
 }
 
 func (b *Breaker) ServeHTTP(ctx *fasthttp.RequestCtx) {
-	appName := string(ctx.Host())
-	var app *Application
-	var exist bool
-	if app, exist = b.apps[appName]; !exist {
-		ctx.WriteString("app " + appName + " not exist")
+	app := b.app(ctx.Host())
+	if app == nil {
+		ctx.WriteString("app ")
+		ctx.Write(ctx.Host())
+		ctx.WriteString(" not exist")
 		ctx.SetStatusCode(fasthttp.StatusNotFound)
 		return
 	}
@@ -35,7 +35,7 @@ func TestBreakerServeHTTP(t *testing.T) {
 	ctx = &fasthttp.RequestCtx{}
 	ctx.Request.SetRequestURI("/")
 	ctx.Request.SetHost(appName)
-	breaker.apps[appName] = a
+	breaker.setApp(appName, a)
 	breaker.ServeHTTP(ctx)
 	if code := ctx.Response.StatusCode(); code != fasthttp.StatusForbidden {
 		t.Errorf("response code should be %d but got: %d", fasthttp.StatusForbidden, code)
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+/*
+traffic splitting, besides its own backends, an application may hold several named groups of
+backends, e.g. a canary group. every group has its own balancer and status ring, requests are
+split between groups by percentage, and a request can be pinned to a group by header or cookie.
+backends of the application itself are the stable group, which receives the rest of traffic.
+*/
+
+const (
+	stableGroup        = "stable"
+	defaultGroupHeader = "X-Guard-Group"
+	defaultGroupCookie = "guard_group"
+)
+
+var (
+	errGroupNameEmpty     = errors.New("name of group is required")
+	errGroupExists        = errors.New("name of group should be unique")
+	errGroupNotFound      = errors.New("group not found")
+	errBadGroupPercent    = errors.New("percent of group should be in [0, 100]")
+	errGroupPercentTooBig = errors.New("sum of percent of groups should not be greater than 100")
+)
+
+type groupConfig struct {
+	Name     string   `json:"name"` // e.g. canary
+	Backends []string `json:"backends"`
+	Weights  []int    `json:"weights"`
+	Percent  int      `json:"percent"` // percent of requests proxied to the group
+}
+
+func checkGroupConfigs(groups []groupConfig) error {
+	names := map[string]bool{stableGroup: true}
+	sum := 0
+
+	for _, g := range groups {
+		if g.Name == "" {
+			return errGroupNameEmpty
+		}
+		if names[g.Name] {
+			return errGroupExists
+		}
+		names[g.Name] = true
+
+		if len(g.Backends) != len(g.Weights) {
+			return errBackendWeightNotMatch
+		}
+		if g.Percent < 0 || g.Percent > 100 {
+			return errBadGroupPercent
+		}
+		sum += g.Percent
+	}
+
+	if sum > 100 {
+		return errGroupPercentTooBig
+	}
+
+	return nil
+}
+
+// backendGroup is a named group of backends
+type backendGroup struct {
+	name     string
+	balancer Balancer
+	status   *Status // requests proxied to the group
+}
+
+func newBackendGroup(name string, balancer Balancer) *backendGroup {
+	return &backendGroup{name: name, balancer: balancer, status: StatusRing()}
+}
+
+// observe record a request proxied to the group
+func (g *backendGroup) observe(code int, latency time.Duration) {
+	addRequest(&g.status, code, latency)
+}
+
+// groupSplit is how requests are split between groups, the first group is the stable one.
+// it's never modified after created, application replace it as a whole.
+type groupSplit struct {
+	groups   []*backendGroup
+	percents []int // percents of groups, the stable one receives the rest
+	header   string
+	cookie   string
+}
+
+func (s *groupSplit) byName(name []byte) *backendGroup {
+	for _, g := range s.groups {
+		if string(name) == g.name {
+			return g
+		}
+	}
+
+	return nil
+}
+
+// pick return the group which the request should be proxied to, pinned group is preferred
+func (s *groupSplit) pick(ctx *fasthttp.RequestCtx) *backendGroup {
+	if name := ctx.Request.Header.Peek(s.header); len(name) > 0 {
+		if g := s.byName(name); g != nil {
+			return g
+		}
+	}
+	if name := ctx.Request.Header.Cookie(s.cookie); len(name) > 0 {
+		if g := s.byName(name); g != nil {
+			return g
+		}
+	}
+
+	n := rand.Intn(100)
+	for i := 1; i < len(s.groups); i++ {
+		if n < s.percents[i] {
+			return s.groups[i]
+		}
+		n -= s.percents[i]
+	}
+
+	return s.groups[0]
+}
+
+// withPercents return a copy of split with percents of groups changed
+func (s *groupSplit) withPercents(percents map[string]int) (*groupSplit, error) {
+	split := &groupSplit{groups: s.groups, percents: append([]int{}, s.percents...), header: s.header, cookie: s.cookie}
+
+	for name, percent := range percents {
+		if percent < 0 || percent > 100 {
+			return nil, errBadGroupPercent
+		}
+
+		found := false
+		for i := 1; i < len(s.groups); i++ {
+			if s.groups[i].name == name {
+				split.percents[i] = percent
+				found = true
+			}
+		}
+		if !found {
+			return nil, errGroupNotFound
+		}
+	}
+
+	sum := 0
+	for i := 1; i < len(split.percents); i++ {
+		sum += split.percents[i]
+	}
+	if sum > 100 {
+		return nil, errGroupPercentTooBig
+	}
+	split.percents[0] = 100 - sum
+
+	return split, nil
+}
+
+// setGroups let the application split requests between its own backends and groups
+func (a *Application) setGroups(groups []*backendGroup, percents []int, header string, cookie string) {
+	if header == "" {
+		header = defaultGroupHeader
+	}
+	if cookie == "" {
+		cookie = defaultGroupCookie
+	}
+
+	split := &groupSplit{
+		groups:   append([]*backendGroup{newBackendGroup(stableGroup, a.balancer)}, groups...),
+		percents: append([]int{100}, percents...),
+		header:   header,
+		cookie:   cookie,
+	}
+	split, _ = split.withPercents(nil)
+	a.split.Store(split)
+}
+
+// groupSplit return how requests are split, nil if the application has no group
+func (a *Application) groupSplit() *groupSplit {
+	split, _ := a.split.Load().(*groupSplit)
+	return split
+}
+
+// SetSplit change percents of groups, percents of groups which are not given are kept
+func (a *Application) SetSplit(percents map[string]int) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	split := a.groupSplit()
+	if split == nil {
+		return errGroupNotFound
+	}
+
+	split, err := split.withPercents(percents)
+	if err != nil {
+		return err
+	}
+	a.split.Store(split)
+
+	if a.config == nil {
+		return nil
+	}
+
+	groups := make([]groupConfig, len(a.config.Groups))
+	copy(groups, a.config.Groups)
+	for i := range groups {
+		groups[i].Percent = split.percents[i+1]
+	}
+	a.config.Groups = groups
+
+	config := *a.config
+	go func() { configSync <- config }()
+
+	return nil
+}
+
+// groupStatus is what admin API shows for a group
+type groupStatus struct {
+	Name     string  `json:"name"`
+	Percent  int     `json:"percent"`
+	Backends int     `json:"backends"`
+	OK       uint64  `json:"ok"` // requests which didn't fail in the current window, see isFailure
+	Failures uint64  `json:"failures"`
+	Ratio    float64 `json:"ratio"` // failure ratio in the current window
+}
+
+// GroupStatus return status of groups, the stable one is the first
+func (a *Application) GroupStatus() []groupStatus {
+	split := a.groupSplit()
+	if split == nil {
+		return []groupStatus{}
+	}
+
+	status := make([]groupStatus, 0, len(split.groups))
+	for i, g := range split.groups {
+		// the same numbers as what the canary is analyzed by
+		requests, failures, _ := sumStatus(&g.status, 1)
+		var ratio float64
+		if requests > 0 {
+			ratio = float64(failures) / float64(requests)
+		}
+		status = append(status, groupStatus{
+			Name: g.name, Percent: split.percents[i], Backends: len(g.balancer.Backends()),
+			OK: requests - failures, Failures: failures, Ratio: ratio,
+		})
+	}
+
+	return status
+}
+
+type splitConfig struct {
+	Name  string         `json:"name"`  // name of the app
+	Split map[string]int `json:"split"` // e.g. {"canary": 10}
+}
+
+// splitHandler show groups of an app by GET, and change percents of them by POST
+func splitHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		app, exist := breaker.get(r.URL.Query().Get("name"))
+		if !exist {
+			writeError(w, http.StatusNotFound, errAPPNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(app.GroupStatus())
+		return
+	}
+
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	defer r.Body.Close()
+	var config splitConfig
+
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	app, exist := breaker.get(config.Name)
+	if !exist {
+		writeError(w, http.StatusNotFound, errAPPNotFound)
+		return
+	}
+
+	switch err := app.SetSplit(config.Split); err {
+	case nil:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(app.GroupStatus())
+	case errGroupNotFound:
+		writeError(w, http.StatusNotFound, err)
+	default:
+		writeError(w, http.StatusBadRequest, err)
+	}
+}
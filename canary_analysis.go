@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/*
+automated canary analysis, the canary group is compared with the stable group over the latest
+windows of their status rings. traffic is shifted back to the stable group as soon as the canary
+is significantly worse, otherwise percent of the canary advances step by step on a schedule.
+*/
+
+const (
+	defaultCanaryStepInterval    = 300 // seconds
+	defaultCanaryWindows         = 6   // one minute
+	defaultCanaryMinRequests     = 20
+	defaultCanaryMaxFailureDelta = 0.05
+	defaultCanaryMaxLatencyRatio = 1.5
+
+	maxCanaryEvents = 100
+
+	canaryRunning    = "running"
+	canaryRolledBack = "rolled_back"
+	canaryPromoted   = "promoted"
+)
+
+var (
+	errCanaryGroupNotFound = errors.New("group of canary analysis not found")
+	errBadCanarySteps      = errors.New("steps of canary analysis should be increasing percents in [1, 100]")
+	errBadCanaryWindows    = errors.New("windows of canary analysis should be in [1, 11]")
+	errBadCanaryThreshold  = errors.New("thresholds of canary analysis should not be negative")
+)
+
+type canaryConfig struct {
+	Group        string `json:"group"`         // name of the canary group
+	Steps        []int  `json:"steps"`         // percents of the canary, e.g. [5, 25, 100]
+	StepInterval int64  `json:"step_interval"` // in seconds, how long the canary stays at a step
+
+	Windows         int     `json:"windows"`           // how many windows of status ring are compared
+	MinRequests     uint64  `json:"min_requests"`      // the canary is not judged until it has so many requests
+	MaxFailureDelta float64 `json:"max_failure_delta"` // max failure ratio of canary minus that of stable
+	MaxLatencyRatio float64 `json:"max_latency_ratio"` // max average latency of canary over that of stable
+}
+
+func checkCanaryConfig(c *canaryConfig, groups []groupConfig) error {
+	found := false
+	for _, g := range groups {
+		found = found || g.Name == c.Group
+	}
+	if !found {
+		return errCanaryGroupNotFound
+	}
+
+	if len(c.Steps) == 0 {
+		c.Steps = []int{5, 25, 100}
+	}
+	for i, step := range c.Steps {
+		if step <= 0 || step > 100 || i > 0 && step <= c.Steps[i-1] {
+			return errBadCanarySteps
+		}
+	}
+
+	if c.Windows < 0 || c.Windows >= maxStatusLen {
+		return errBadCanaryWindows
+	}
+	if c.StepInterval < 0 || c.MaxFailureDelta < 0 || c.MaxLatencyRatio < 0 {
+		return errBadCanaryThreshold
+	}
+
+	if c.StepInterval == 0 {
+		c.StepInterval = defaultCanaryStepInterval
+	}
+	if c.Windows == 0 {
+		c.Windows = defaultCanaryWindows
+	}
+	if c.MinRequests == 0 {
+		c.MinRequests = defaultCanaryMinRequests
+	}
+	if c.MaxFailureDelta == 0 {
+		c.MaxFailureDelta = defaultCanaryMaxFailureDelta
+	}
+	if c.MaxLatencyRatio == 0 {
+		c.MaxLatencyRatio = defaultCanaryMaxLatencyRatio
+	}
+
+	return nil
+}
+
+// canaryEvent is what happened to the canary
+type canaryEvent struct {
+	Time    int64  `json:"time"`
+	State   string `json:"state"` // running, rolled_back, promoted
+	Percent int    `json:"percent"`
+	Reason  string `json:"reason"`
+}
+
+// canaryAnalyzer drives the canary of an application
+type canaryAnalyzer struct {
+	config canaryConfig
+	app    *Application
+
+	lock      sync.Mutex
+	state     string
+	step      int   // index of current step
+	steppedAt int64 // when the canary entered the current step
+	events    []canaryEvent
+}
+
+func newCanaryAnalyzer(a *Application, c *canaryConfig) *canaryAnalyzer {
+	return &canaryAnalyzer{config: *c, app: a}
+}
+
+// emit should be called with lock held
+func (c *canaryAnalyzer) emit(now int64, state string, reason string) {
+	c.state = state
+	event := canaryEvent{Time: now, State: state, Percent: c.percent(), Reason: reason}
+	log.Printf("canary %s is %s at %d%%: %s", c.config.Group, state, event.Percent, reason)
+
+	c.events = append(c.events, event)
+	if len(c.events) > maxCanaryEvents {
+		c.events = c.events[len(c.events)-maxCanaryEvents:]
+	}
+}
+
+// percent should be called with lock held
+func (c *canaryAnalyzer) percent() int {
+	if c.state == canaryRolledBack {
+		return 0
+	}
+	return c.config.Steps[c.step]
+}
+
+func (c *canaryAnalyzer) setPercent(percent int) {
+	if err := c.app.SetSplit(map[string]int{c.config.Group: percent}); err != nil {
+		log.Printf("failed to set percent of canary %s to %d: %s", c.config.Group, percent, err)
+	}
+}
+
+// start put the canary on the first step
+func (c *canaryAnalyzer) start(now int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.step, c.steppedAt = 0, now
+	c.setPercent(c.percent())
+	if len(c.config.Steps) == 1 {
+		// nothing to analyze, e.g. steps are [100]
+		c.emit(now, canaryPromoted, "canary started on the last step")
+		return
+	}
+	c.emit(now, canaryRunning, "canary started")
+}
+
+// compare return why the canary is significantly worse than stable, empty if it's not. ok is
+// false if the canary does not have enough requests to be judged.
+func (c *canaryAnalyzer) compare() (reason string, ok bool) {
+	split := c.app.groupSplit()
+	if split == nil {
+		return "", false
+	}
+	stable, canary := split.groups[0], split.byName([]byte(c.config.Group))
+	if canary == nil {
+		return "", false
+	}
+
+	canaryRequests, canaryFailures, canaryLatency := sumStatus(&canary.status, c.config.Windows)
+	if canaryRequests < c.config.MinRequests {
+		return "", false
+	}
+	stableRequests, stableFailures, stableLatency := sumStatus(&stable.status, c.config.Windows)
+
+	canaryRatio := float64(canaryFailures) / float64(canaryRequests)
+	stableRatio := 0.0
+	if stableRequests > 0 {
+		stableRatio = float64(stableFailures) / float64(stableRequests)
+	}
+
+	if canaryRatio-stableRatio > c.config.MaxFailureDelta {
+		return fmt.Sprintf("failure ratio %.3f of canary is worse than %.3f of stable", canaryRatio, stableRatio), true
+	}
+	if stableLatency > 0 && float64(canaryLatency) > float64(stableLatency)*c.config.MaxLatencyRatio {
+		return fmt.Sprintf("latency %s of canary is worse than %s of stable", canaryLatency, stableLatency), true
+	}
+
+	return "", true
+}
+
+// analyze roll the canary back if it's worse than stable, or advance it to the next step if
+// it has been healthy on the current step for step interval
+func (c *canaryAnalyzer) analyze(now int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.state != canaryRunning {
+		return
+	}
+
+	reason, judged := c.compare()
+	if reason != "" {
+		c.emit(now, canaryRolledBack, reason)
+		c.setPercent(0)
+		return
+	}
+
+	if !judged || now-c.steppedAt < c.config.StepInterval || c.step == len(c.config.Steps)-1 {
+		return
+	}
+
+	c.step++
+	c.steppedAt = now
+	c.setPercent(c.percent())
+	if c.step == len(c.config.Steps)-1 {
+		c.emit(now, canaryPromoted, "canary is healthy on every step")
+	} else {
+		c.emit(now, canaryRunning, "canary is healthy, advance to the next step")
+	}
+}
+
+// run analyze the canary once per status window, until the application is closed
+func (c *canaryAnalyzer) run() {
+	c.start(CoarseTimeNow().Unix())
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(statusStep) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-c.app.stop:
+				return
+			case <-ticker.C:
+				c.analyze(CoarseTimeNow().Unix())
+			}
+		}
+	}()
+}
+
+// canaryStatus is what admin API shows for the canary of an application
+type canaryStatus struct {
+	Group   string        `json:"group"`
+	State   string        `json:"state"`
+	Percent int           `json:"percent"`
+	Events  []canaryEvent `json:"events"`
+}
+
+func (c *canaryAnalyzer) status() canaryStatus {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return canaryStatus{
+		Group: c.config.Group, State: c.state, Percent: c.percent(),
+		Events: append([]canaryEvent{}, c.events...),
+	}
+}
+
+func canaryHandler(w http.ResponseWriter, r *http.Request) {
+	app, exist := breaker.get(r.URL.Query().Get("name"))
+	if !exist {
+		writeError(w, http.StatusNotFound, errAPPNotFound)
+		return
+	}
+	if app.canary == nil {
+		writeError(w, http.StatusNotFound, errCanaryGroupNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(app.canary.status())
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheckCanaryConfig(t *testing.T) {
+	groups := []groupConfig{{Name: "canary"}}
+
+	c := &canaryConfig{Group: "canary"}
+	if err := checkCanaryConfig(c, groups); err != nil {
+		t.Errorf("should not return error but got: %s", err)
+	}
+	if len(c.Steps) != 3 || c.StepInterval != defaultCanaryStepInterval || c.Windows != defaultCanaryWindows ||
+		c.MinRequests != defaultCanaryMinRequests || c.MaxFailureDelta != defaultCanaryMaxFailureDelta ||
+		c.MaxLatencyRatio != defaultCanaryMaxLatencyRatio {
+		t.Errorf("canary config should be set to default, but got: %+v", c)
+	}
+
+	expects := []struct {
+		config canaryConfig
+		err    error
+	}{
+		{canaryConfig{Group: "beta"}, errCanaryGroupNotFound},
+		{canaryConfig{Group: "canary", Steps: []int{0, 100}}, errBadCanarySteps},
+		{canaryConfig{Group: "canary", Steps: []int{50, 25}}, errBadCanarySteps},
+		{canaryConfig{Group: "canary", Steps: []int{50, 101}}, errBadCanarySteps},
+		{canaryConfig{Group: "canary", Windows: maxStatusLen}, errBadCanaryWindows},
+		{canaryConfig{Group: "canary", MaxLatencyRatio: -1}, errBadCanaryThreshold},
+	}
+	for i, e := range expects {
+		if err := checkCanaryConfig(&e.config, groups); err != e.err {
+			t.Errorf("the %dth config should return %v but got: %v", i, e.err, err)
+		}
+	}
+}
+
+// newCanaryApp return an application with a canary group under analysis
+func newCanaryApp() (*Application, *canaryAnalyzer) {
+	a := NewApp(NewRR(NewBackend("192.168.1.1:80", 1)), true)
+	a.setGroups([]*backendGroup{newBackendGroup("canary", NewRR(NewBackend("192.168.2.1:80", 1)))}, []int{0}, "", "")
+
+	config := &canaryConfig{Group: "canary", StepInterval: 30}
+	checkCanaryConfig(config, []groupConfig{{Name: "canary"}})
+	a.canary = newCanaryAnalyzer(a, config)
+
+	return a, a.canary
+}
+
+func observeGroups(a *Application, stableCode int, canaryCode int, canaryLatency time.Duration) {
+	split := a.groupSplit()
+	for i := 0; i < 30; i++ {
+		split.groups[0].observe(stableCode, 10*time.Millisecond)
+		split.groups[1].observe(canaryCode, canaryLatency)
+	}
+}
+
+func TestCanaryPromote(t *testing.T) {
+	a, c := newCanaryApp()
+	now := CoarseTimeNow().Unix()
+
+	c.start(now)
+	if percent := a.groupSplit().percents[1]; percent != 5 {
+		t.Errorf("canary should start at 5%%, but got: %d", percent)
+	}
+
+	// not enough requests to judge
+	c.analyze(now + 30)
+	if percent := a.groupSplit().percents[1]; percent != 5 {
+		t.Errorf("canary should stay at 5%% without enough requests, but got: %d", percent)
+	}
+
+	observeGroups(a, http.StatusOK, http.StatusOK, 10*time.Millisecond)
+	c.analyze(now + 10)
+	if percent := a.groupSplit().percents[1]; percent != 5 {
+		t.Errorf("canary should stay at 5%% until step interval is over, but got: %d", percent)
+	}
+
+	for i, expected := range []int{25, 100} {
+		c.analyze(now + int64(i+1)*30)
+		if percent := a.groupSplit().percents[1]; percent != expected {
+			t.Errorf("canary should advance to %d%%, but got: %d", expected, percent)
+		}
+	}
+
+	status := c.status()
+	if status.State != canaryPromoted || status.Percent != 100 || len(status.Events) != 3 {
+		t.Errorf("canary should be promoted, but got: %+v", status)
+	}
+
+	// analysis is over
+	c.analyze(now + 120)
+	if len(c.status().Events) != 3 {
+		t.Errorf("canary should not be analyzed after promoted")
+	}
+}
+
+func TestCanaryOneStep(t *testing.T) {
+	a, c := newCanaryApp()
+	c.config.Steps = []int{100}
+	now := CoarseTimeNow().Unix()
+
+	c.start(now)
+	if percent := a.groupSplit().percents[1]; percent != 100 || c.state != canaryPromoted {
+		t.Errorf("canary should be promoted at 100%%, but got: %s, %d", c.state, percent)
+	}
+
+	// never advances past the only step
+	observeGroups(a, http.StatusOK, http.StatusOK, 10*time.Millisecond)
+	c.analyze(now + 30)
+	if status := c.status(); status.Percent != 100 || status.State != canaryPromoted {
+		t.Errorf("canary should stay promoted at 100%%, but got: %+v", status)
+	}
+}
+
+func TestCanaryRollback(t *testing.T) {
+	for _, e := range []struct {
+		code    int
+		latency time.Duration
+	}{
+		{http.StatusInternalServerError, 10 * time.Millisecond},
+		{http.StatusServiceUnavailable, 10 * time.Millisecond},
+		{http.StatusOK, 100 * time.Millisecond},
+		{http.StatusNotFound, 100 * time.Millisecond},
+	} {
+		a, c := newCanaryApp()
+		now := CoarseTimeNow().Unix()
+		c.start(now)
+
+		observeGroups(a, http.StatusOK, e.code, e.latency)
+		c.analyze(now + 10)
+
+		status := c.status()
+		if percent := a.groupSplit().percents[1]; percent != 0 || status.State != canaryRolledBack || status.Percent != 0 {
+			t.Errorf("canary should be rolled back, but got: %d%%, %+v", percent, status)
+		}
+		if event := status.Events[len(status.Events)-1]; event.State != canaryRolledBack || event.Reason == "" {
+			t.Errorf("should emit a rollback event, but got: %+v", event)
+		}
+	}
+}
+
+func TestCanaryNotStartedByGetAPP(t *testing.T) {
+	config := &appConfig{
+		Name: "canary.example.com", Backends: []string{"192.168.1.1:80"}, Weights: []int{1},
+		Groups: []groupConfig{{Name: "canary", Backends: []string{"192.168.2.1:80"}, Weights: []int{1}}},
+		Canary: &canaryConfig{Group: "canary"},
+	}
+	if err := checkAppConfig(config); err != nil {
+		t.Fatalf("should not return error but got: %s", err)
+	}
+
+	// the app may still be rejected, so the split is untouched until it's set
+	a, err := getAPP(config)
+	if err != nil {
+		t.Fatalf("should not return error but got: %s", err)
+	}
+	defer a.Close()
+	if percent := a.groupSplit().percents[1]; percent != 0 || len(a.canary.status().Events) != 0 {
+		t.Errorf("canary should not be started, but got: %d%%, %+v", percent, a.canary.status())
+	}
+}
+
+func TestCanaryHandler(t *testing.T) {
+	fakeServer := httptest.NewServer(http.HandlerFunc(canaryHandler))
+	defer fakeServer.Close()
+	url := fakeServer.URL + "/app/canary"
+
+	appName := "canary.example.com"
+	a, c := newCanaryApp()
+	c.start(CoarseTimeNow().Unix())
+	breaker.apps[appName] = a
+	defer delete(breaker.apps, appName)
+
+	resp, err := http.Get(url + "?name=" + appName)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get status of canary: %s", err)
+	}
+	defer resp.Body.Close()
+	status := canaryStatus{}
+	json.NewDecoder(resp.Body).Decode(&status)
+	if status.Group != "canary" || status.State != canaryRunning || status.Percent != 5 {
+		t.Errorf("status of canary is wrong: %+v", status)
+	}
+
+	breaker.apps["nocanary.example.com"] = NewApp(NewRR(), true)
+	defer delete(breaker.apps, "nocanary.example.com")
+	for _, name := range []string{"what.example.com", "nocanary.example.com"} {
+		resp, err := http.Get(url + "?name=" + name)
+		if err != nil || resp.StatusCode != http.StatusNotFound {
+			t.Errorf("should return 404 for %s", name)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+// inmemoryBackend return a backend which is served by handler in memory
+func inmemoryBackend(t *testing.T, handler fasthttp.RequestHandler) (Backend, func()) {
+	ln := fasthttputil.NewInmemoryListener()
+	go fasthttp.Serve(ln, handler)
+
+	b := NewBackend(ln.Addr().String(), 1)
+	b.client.Dial = func(addr string) (net.Conn, error) { return ln.Dial() }
+
+	return b, func() { ln.Close() }
+}
+
+func TestCheckGroupConfigs(t *testing.T) {
+	expects := []struct {
+		groups []groupConfig
+		err    error
+	}{
+		{[]groupConfig{{Name: "canary", Backends: []string{"192.168.1.1:80"}, Weights: []int{1}, Percent: 10}}, nil},
+		{[]groupConfig{{Backends: []string{}, Weights: []int{}}}, errGroupNameEmpty},
+		{[]groupConfig{{Name: "stable"}}, errGroupExists},
+		{[]groupConfig{{Name: "canary"}, {Name: "canary"}}, errGroupExists},
+		{[]groupConfig{{Name: "canary", Backends: []string{"192.168.1.1:80"}}}, errBackendWeightNotMatch},
+		{[]groupConfig{{Name: "canary", Percent: -1}}, errBadGroupPercent},
+		{[]groupConfig{{Name: "canary", Percent: 60}, {Name: "beta", Percent: 50}}, errGroupPercentTooBig},
+	}
+	for i, e := range expects {
+		if err := checkGroupConfigs(e.groups); err != e.err {
+			t.Errorf("the %dth groups should return %v but got: %v", i, e.err, err)
+		}
+	}
+}
+
+func TestGroupSplit(t *testing.T) {
+	a := NewApp(NewRR(NewBackend("192.168.1.1:80", 1)), true)
+	if a.groupSplit() != nil || a.SetSplit(map[string]int{"canary": 10}) != errGroupNotFound {
+		t.Errorf("app without group should not split requests")
+	}
+
+	a.setGroups([]*backendGroup{newBackendGroup("canary", NewRR(NewBackend("192.168.2.1:80", 1)))}, []int{30}, "", "")
+	split := a.groupSplit()
+	if split.percents[0] != 70 || split.header != defaultGroupHeader || split.cookie != defaultGroupCookie {
+		t.Fatalf("split is wrong: %+v", split)
+	}
+
+	count := func(header string, cookie string) int {
+		canary := 0
+		for i := 0; i < 1000; i++ {
+			ctx := &fasthttp.RequestCtx{}
+			if header != "" {
+				ctx.Request.Header.Set(defaultGroupHeader, header)
+			}
+			if cookie != "" {
+				ctx.Request.Header.SetCookie(defaultGroupCookie, cookie)
+			}
+			if a.groupSplit().pick(ctx).name == "canary" {
+				canary++
+			}
+		}
+		return canary
+	}
+
+	if n := count("", ""); n < 200 || n > 400 {
+		t.Errorf("canary should receive about 30%% of requests, but got %d of 1000", n)
+	}
+	if n := count("canary", ""); n != 1000 {
+		t.Errorf("requests should be pinned to canary by header, but got %d of 1000", n)
+	}
+	if n := count("", "canary"); n != 1000 {
+		t.Errorf("requests should be pinned to canary by cookie, but got %d of 1000", n)
+	}
+	if n := count("stable", "canary"); n != 0 {
+		t.Errorf("header should take precedence over cookie, but got %d of 1000", n)
+	}
+	if n := count("what", ""); n == 0 || n == 1000 {
+		t.Errorf("unknown group should be ignored, but got %d of 1000", n)
+	}
+
+	if err := a.SetSplit(map[string]int{"canary": 0}); err != nil {
+		t.Errorf("should not return error but got: %s", err)
+	}
+	if n := count("", ""); n != 0 {
+		t.Errorf("canary should not receive requests, but got %d of 1000", n)
+	}
+
+	for _, e := range []struct {
+		percents map[string]int
+		err      error
+	}{
+		{map[string]int{"beta": 10}, errGroupNotFound},
+		{map[string]int{"stable": 10}, errGroupNotFound},
+		{map[string]int{"canary": 101}, errBadGroupPercent},
+	} {
+		if err := a.SetSplit(e.percents); err != e.err {
+			t.Errorf("should return %s but got: %s", e.err, err)
+		}
+	}
+}
+
+func TestGroupServeHTTP(t *testing.T) {
+	// every status code is counted, not only 200, 429, 500 and 502
+	stable, stopStable := inmemoryBackend(t, func(ctx *fasthttp.RequestCtx) { ctx.SetStatusCode(fasthttp.StatusCreated) })
+	defer stopStable()
+	canary, stopCanary := inmemoryBackend(t, func(ctx *fasthttp.RequestCtx) { ctx.SetStatusCode(fasthttp.StatusServiceUnavailable) })
+	defer stopCanary()
+
+	a := NewApp(NewRR(stable), true)
+	a.AddRoute("/", "GET")
+	a.setGroups([]*backendGroup{newBackendGroup("canary", NewRR(canary))}, []int{0}, "X-Group", "")
+
+	// not too many failures, or the circuit breaker of route will be open
+	for _, group := range []string{"", "", "", "", "canary", "canary"} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("http://example.com/")
+		ctx.Request.Header.Set("X-Group", group)
+		a.ServeHTTP(ctx)
+	}
+
+	status := a.GroupStatus()
+	if len(status) != 2 || status[0].OK != 4 || status[0].Percent != 100 || status[1].Failures != 2 || status[1].Ratio != 1 {
+		t.Errorf("status of groups is wrong: %+v", status)
+	}
+}
+
+func TestSplitHandler(t *testing.T) {
+	fakeServer := httptest.NewServer(http.HandlerFunc(splitHandler))
+	defer fakeServer.Close()
+	url := fakeServer.URL + "/app/split"
+
+	appName := "split.example.com"
+	a := NewApp(NewRR(NewBackend("192.168.1.1:80", 1)), true)
+	a.setGroups([]*backendGroup{newBackendGroup("canary", NewRR(NewBackend("192.168.2.1:80", 1)))}, []int{5}, "", "")
+	breaker.apps[appName] = a
+	defer delete(breaker.apps, appName)
+
+	expects := []struct {
+		method string
+		body   string
+		code   int
+	}{
+		{"POST", `{"name":"split.example.com","split":{"canary":25}}`, http.StatusOK},
+		{"POST", `{"name":"split.example.com","split":{"beta":25}}`, http.StatusNotFound},
+		{"POST", `{"name":"split.example.com","split":{"canary":125}}`, http.StatusBadRequest},
+		{"POST", `{"name":"what.example.com","split":{"canary":25}}`, http.StatusNotFound},
+		{"POST", `what`, http.StatusBadRequest},
+		{"PUT", ``, http.StatusMethodNotAllowed},
+	}
+	for i, e := range expects {
+		req, _ := http.NewRequest(e.method, url, bytes.NewBufferString(e.body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to request split handler: %s", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != e.code {
+			t.Errorf("the %dth request should return %d but got: %d", i, e.code, resp.StatusCode)
+		}
+	}
+
+	resp, err := http.Get(url + "?name=" + appName)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get status of groups: %s", err)
+	}
+	defer resp.Body.Close()
+	status := []groupStatus{}
+	json.NewDecoder(resp.Body).Decode(&status)
+	if len(status) != 2 || status[0].Name != stableGroup || status[0].Percent != 75 || status[1].Percent != 25 {
+		t.Errorf("status of groups is wrong: %+v", status)
+	}
+}
+
+func TestGroupConfig(t *testing.T) {
+	config := &appConfig{
+		Name: "group.example.com", Backends: []string{"192.168.1.1:80"}, Weights: []int{1},
+		Groups: []groupConfig{{Name: "canary", Backends: []string{"192.168.2.1:80"}, Weights: []int{1}, Percent: 10}},
+	}
+	if err := checkAppConfig(config); err != nil {
+		t.Fatalf("should not return error but got: %s", err)
+	}
+
+	a, _ := getAPP(config)
+	status := a.GroupStatus()
+	if len(status) != 2 || status[0].Percent != 90 || status[1].Name != "canary" || status[1].Backends != 1 {
+		t.Errorf("status of groups is wrong: %+v", status)
+	}
+}
@@ -7,7 +7,9 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -20,10 +22,22 @@ const (
 var (
 	errNameEmpty               = errors.New("name is required")
 	errBackendWeightNotMatch   = errors.New("backend and weight does not match")
+	errBackupWeightNotMatch    = errors.New("backup backend and backup weight does not match")
 	errPathMethodNotMatch      = errors.New("path and method does not match")
 	errBadLoadBalanceAlgorithm = errors.New("bad load balance algorithm, only wrr, rr, random are support now")
 	errBadFallbackType         = errors.New("bad fallback type")
 
+	errBadOutlierErrorRatio      = errors.New("error ratio of outlier detection should be in [0, 1]")
+	errBadOutlierEjectionPercent = errors.New("max ejection percent of outlier detection should be in [0, 100]")
+	errBadOutlierEjectionTime    = errors.New("ejection time of outlier detection should not be negative")
+
+	errBadSlowStartDuration   = errors.New("duration of slow start should be positive")
+	errBadSlowStartMinWeight  = errors.New("min weight percent of slow start should be in [0, 100]")
+	errBadSlowStartAggression = errors.New("aggression of slow start should not be negative")
+
+	errBackendZoneNotMatch  = errors.New("backend and zone does not match")
+	errBadMinHealthyPercent = errors.New("min healthy percent of zone aware should be in [0, 100]")
+
 	configSync = make(chan appConfig)
 )
 
@@ -38,19 +52,147 @@ type appConfig struct {
 	Methods           []string `json:"methods"`
 	FallbackType      string   `json:"fallback_type"`
 	FallbackContent   string   `json:"fallback_content"`
+
+	// methods besides the common ones which can be used in methods, e.g. ["PROPFIND", "PURGE"]
+	ExtensionMethods []string `json:"extension_methods,omitempty"`
+
+	// backup backends are used only when all backends are unavailable
+	BackupBackends []string `json:"backup_backends,omitempty"`
+	BackupWeights  []int    `json:"backup_weights,omitempty"`
+
+	Outlier   *outlierConfig   `json:"outlier_detection,omitempty"` // disabled if it's nil
+	SlowStart *slowStartConfig `json:"slow_start,omitempty"`        // disabled if it's nil
+
+	Discovery []discoveryConfig `json:"discovery,omitempty"` // find backends besides the ones above
+
+	Zones     []string         `json:"zones,omitempty"`      // zone of every backend, e.g. ["us-east-1a", "us-east-1b"]
+	ZoneAware *zoneAwareConfig `json:"zone_aware,omitempty"` // disabled if it's nil
+
+	// groups of backends besides the ones above, e.g. canary, requests can be pinned to a group
+	// by header or cookie whose value is name of the group
+	Groups      []groupConfig `json:"groups,omitempty"`
+	GroupHeader string        `json:"group_header,omitempty"` // X-Guard-Group by default
+	GroupCookie string        `json:"group_cookie,omitempty"` // guard_group by default
+
+	Canary *canaryConfig `json:"canary,omitempty"` // canary analysis of a group, disabled if it's nil
+
+	Rules []ruleConfig `json:"rules,omitempty"` // proxy requests which match headers, query or cookies to groups
+
+	Routes []routeConfig `json:"routes,omitempty"` // routes with their own backends, timeout or rewriting
+
+	// pattern of route and wildcards captured from path are forwarded to backends in headers,
+	// e.g. `X-Guard-Route: /user/:name` and `X-Guard-Param-name: alice`, disabled if empty
+	RouteHeader       string `json:"route_header,omitempty"`        // e.g. X-Guard-Route
+	ParamHeaderPrefix string `json:"param_header_prefix,omitempty"` // e.g. X-Guard-Param-
+
+	// a request which matches no route is cleaned and matched case-insensitively, and then
+	// redirected to the fixed path if it's redirect, or served silently if it's match
+	FixPath string `json:"fix_path,omitempty"`
+
+	AutoOptions *optionsConfig `json:"auto_options,omitempty"` // OPTIONS are proxied if it's nil
+
+	// requests which match no path are proxied by the default route instead of 404, routes and
+	// rules can be configured for it with path `*`
+	DefaultRoute bool `json:"default_route,omitempty"`
+
+	// hosts besides the name which the app serves, e.g. ["example.com", "*.example.com"], and
+	// the default app serves requests whose host matches no app
+	Hosts      []string `json:"hosts,omitempty"`
+	DefaultApp bool     `json:"default_app,omitempty"`
+}
+
+// configErrors are all the problems found in a configuration
+type configErrors []error
+
+func (errs configErrors) Error() string {
+	problems := make([]string, len(errs))
+	for i, err := range errs {
+		problems[i] = err.Error()
+	}
+	return strings.Join(problems, "; ")
+}
+
+// err return nil if there is no problem, the problem itself if there is only one
+func (errs configErrors) err() error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	default:
+		return errs
+	}
 }
 
+// checkPaths add paths to a radix tree to find bad paths and methods, as what getAPP does.
+// methods are known if they're in t
+func checkPaths(paths, methods []string, t *methodTable) configErrors {
+	errs := configErrors{}
+	root := &node{}
+	for i, path := range paths {
+		httpMethod, err := t.convert(strings.ToUpper(methods[i]))
+		if err != nil {
+			errs = append(errs, &pathError{path: path, err: err, detail: methods[i]})
+			continue
+		}
+
+		// a bad path may leave the tree half changed, so it's added to a copy
+		tree := root.clone()
+		if _, err := tree.addRoute([]byte(path), httpMethod); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		root = tree
+	}
+
+	return errs
+}
+
+// checkAppConfig return every problem of the configuration, as configErrors if there are more
+// than one
 func checkAppConfig(a *appConfig) error {
+	errs := configErrors{}
 	if a.Name == "" {
-		return errNameEmpty
+		errs = append(errs, errNameEmpty)
+	}
+
+	if err := checkHosts(a.Hosts); err != nil {
+		errs = append(errs, err)
 	}
 
 	if len(a.Backends) != len(a.Weights) {
-		return errBackendWeightNotMatch
+		errs = append(errs, errBackendWeightNotMatch)
+	}
+
+	if len(a.Zones) > 0 && len(a.Zones) != len(a.Backends) {
+		errs = append(errs, errBackendZoneNotMatch)
+	}
+
+	if len(a.BackupBackends) != len(a.BackupWeights) {
+		errs = append(errs, errBackupWeightNotMatch)
+	}
+
+	// extension methods are registered by getAPP once the app is accepted, so a rejected one
+	// never takes their bits. paths are checked as if they were registered
+	var names []string
+	for i, name := range a.ExtensionMethods {
+		a.ExtensionMethods[i] = strings.ToUpper(name)
+		if !isToken(a.ExtensionMethods[i]) {
+			errs = append(errs, errBadMethodName)
+			continue
+		}
+		names = append(names, a.ExtensionMethods[i])
+	}
+	methods, err := methodTableNow().with(names...)
+	if err != nil {
+		errs = append(errs, err)
+		methods = methodTableNow()
 	}
 
 	if len(a.Paths) != len(a.Methods) {
-		return errPathMethodNotMatch
+		errs = append(errs, errPathMethodNotMatch)
+	} else {
+		errs = append(errs, checkPaths(a.Paths, a.Methods, methods)...)
 	}
 
 	if a.LoadBalanceMethod == "" {
@@ -69,20 +211,81 @@ func checkAppConfig(a *appConfig) error {
 	case fallbackHTMLFile:
 		html, err := ioutil.ReadFile(a.FallbackContent)
 		if err != nil {
-			return err
+			errs = append(errs, err)
+		} else {
+			a.FallbackType = fallbackHTML
+			a.FallbackContent = string(html)
 		}
-		a.FallbackType = fallbackHTML
-		a.FallbackContent = string(html)
 	default:
-		return errBadFallbackType
+		errs = append(errs, errBadFallbackType)
+	}
+
+	if a.Outlier != nil {
+		if err := checkOutlierConfig(a.Outlier); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if a.SlowStart != nil {
+		if err := checkSlowStartConfig(a.SlowStart); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if a.AutoOptions != nil {
+		if err := checkOptionsConfig(a.AutoOptions); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := checkGroupConfigs(a.Groups); err != nil {
+		errs = append(errs, err)
+	}
+
+	routePaths := a.Paths
+	if a.DefaultRoute {
+		routePaths = append(routePaths[:len(routePaths):len(routePaths)], defaultRoutePath)
+	}
+
+	if err := checkRuleConfigs(a.Rules, routePaths, a.Groups); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := checkRouteConfigs(a.Routes, routePaths, a.LoadBalanceMethod); err != nil {
+		errs = append(errs, err)
+	}
+
+	if a.Canary != nil {
+		if err := checkCanaryConfig(a.Canary, a.Groups); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if a.ZoneAware != nil {
+		if err := checkZoneAwareConfig(a.ZoneAware); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for i := range a.Discovery {
+		if err := checkDiscoveryConfig(&a.Discovery[i]); err != nil {
+			errs = append(errs, err)
+		}
 	}
 
 	switch a.LoadBalanceMethod {
 	case LBMWRR, LBMRR, LBMRandom:
-		return nil
 	default:
-		return errBadLoadBalanceAlgorithm
+		errs = append(errs, errBadLoadBalanceAlgorithm)
+	}
+
+	switch a.FixPath {
+	case "", fixPathRedirect, fixPathMatch:
+	default:
+		errs = append(errs, errBadFixPath)
 	}
+
+	return errs.err()
 }
 
 func getBalancer(loadBalanceMethod string, backends ...Backend) Balancer {
@@ -99,24 +302,131 @@ func getBalancer(loadBalanceMethod string, backends ...Backend) Balancer {
 	}
 }
 
-func getAPP(config *appConfig) *Application {
+// getAPP build an application from a checked configuration, the application is closed and an
+// error is returned if it can't be built
+func getAPP(config *appConfig) (app *Application, err error) {
 	backends := []Backend{}
 	for i, url := range config.Backends {
-		backends = append(backends, NewBackend(url, config.Weights[i]))
+		backend := NewBackend(url, config.Weights[i])
+		if len(config.Zones) > 0 {
+			backend.Zone = config.Zones[i]
+		}
+		backends = append(backends, backend)
+	}
+	for i, url := range config.BackupBackends {
+		backend := NewBackend(url, config.BackupWeights[i])
+		backend.Backup = true
+		backends = append(backends, backend)
+	}
+	var balancer Balancer
+	if config.ZoneAware != nil {
+		newBalancer := func(backends ...Backend) Balancer { return getBalancer(config.LoadBalanceMethod, backends...) }
+		balancer = NewZoned(*localZone, config.ZoneAware.MinHealthyPercent, newBalancer, backends...)
+	} else {
+		balancer = getBalancer(config.LoadBalanceMethod, backends...)
+	}
+
+	app = NewApp(balancer, !config.DisableTSR)
+	defer func() {
+		if err != nil {
+			app.Close()
+			app = nil
+		}
+	}()
+
+	app.config = config
+	app.detector = newOutlierDetector(config.Outlier)
+	app.slowStart = config.SlowStart
+	app.watch(backends...)
+
+	if len(config.Groups) > 0 || len(config.Rules) > 0 {
+		groups, percents := []*backendGroup{}, []int{}
+		for _, g := range config.Groups {
+			backends := []Backend{}
+			for i, url := range g.Backends {
+				backends = append(backends, NewBackend(url, g.Weights[i]))
+			}
+			app.watch(backends...)
+
+			groups = append(groups, newBackendGroup(g.Name, getBalancer(config.LoadBalanceMethod, backends...)))
+			percents = append(percents, g.Percent)
+		}
+		app.setGroups(groups, percents, config.GroupHeader, config.GroupCookie)
 	}
 
-	balancer := getBalancer(config.LoadBalanceMethod, backends...)
+	// the canary is started once the app is set, so a rejected app never changes the split
+	if config.Canary != nil {
+		app.canary = newCanaryAnalyzer(app, config.Canary)
+	}
 
-	app := NewApp(balancer, !config.DisableTSR)
+	for i := range config.Discovery {
+		app.startDiscovery(config.Name+"/discovery/"+strconv.Itoa(i), &config.Discovery[i])
+	}
 
+	for _, name := range config.ExtensionMethods {
+		if _, err := RegisterMethod(name); err != nil {
+			return app, err
+		}
+	}
 	for i, path := range config.Paths {
-		app.AddRoute(path, strings.ToUpper(config.Methods[i]))
+		if err := app.AddRoute(path, strings.ToUpper(config.Methods[i])); err != nil {
+			return app, err
+		}
+	}
+	app.SetDefaultRoute(config.DefaultRoute)
+	for _, r := range config.Routes {
+		var balancer Balancer
+		if len(r.Backends) > 0 {
+			backends := []Backend{}
+			for i, url := range r.Backends {
+				backends = append(backends, NewBackend(url, r.Weights[i]))
+			}
+			app.watch(backends...)
+			balancer = getBalancer(r.LoadBalanceMethod, backends...)
+		}
+
+		if err := app.SetRouteTarget(r.Path, balancer, time.Duration(r.Timeout)*time.Millisecond); err != nil {
+			return app, err
+		}
+
+		rw, err := newRewrite(r.Path, r.StripPrefix, r.AddPrefix, r.Rewrite)
+		if err == nil {
+			err = app.SetRouteRewrite(r.Path, rw)
+		}
+		if err != nil {
+			return app, err
+		}
+	}
+	for _, rule := range config.Rules {
+		if err := app.AddRule(rule); err != nil {
+			return app, err
+		}
 	}
 
 	app.fallbackType = config.FallbackType
+	app.fixPath = config.FixPath
+	app.hosts = config.Hosts
+	app.defaultApp = config.DefaultApp
+	app.options = newAutoOptions(config.AutoOptions)
+	app.routeHeader = config.RouteHeader
+	app.paramHeaderPrefix = []byte(config.ParamHeaderPrefix)
 	app.FallbackContent = []byte(config.FallbackContent)
 
-	return app
+	return app, nil
+}
+
+// writeConfigError answer 400 with every problem of the configuration, one per line
+func writeConfigError(w http.ResponseWriter, err error) {
+	problems, ok := err.(configErrors)
+	if !ok {
+		problems = configErrors{err}
+	}
+
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write([]byte("bad configuration:"))
+	for _, p := range problems {
+		w.Write([]byte("\n" + p.Error()))
+	}
 }
 
 func appHandler(w http.ResponseWriter, r *http.Request) {
@@ -130,19 +440,24 @@ func appHandler(w http.ResponseWriter, r *http.Request) {
 
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&config); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("bad configuration: " + err.Error()))
+		writeConfigError(w, err)
 		return
 	}
 
 	if err := checkAppConfig(&config); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("bad configuration: " + err.Error()))
+		writeConfigError(w, err)
 		return
 	}
 
-	// replace breaker's map, FIXME: here may raise data race...
-	breaker.apps[config.Name] = getAPP(&config)
+	// the running app is kept if the new one can't be built, or its hosts are used
+	old, err := breaker.buildApp(config.Name, &config)
+	if err != nil {
+		writeConfigError(w, err)
+		return
+	}
+	if old != nil {
+		old.Close()
+	}
 
 	go func() { configSync <- config }()
 	w.Write([]byte("success!"))
@@ -173,7 +488,14 @@ func configKeeper() {
 	if err := json.Unmarshal(fileBytes, &b); err == nil {
 		log.Printf("loading config from config file")
 		for k, v := range b.APPs {
-			breaker.apps[k] = getAPP(&v)
+			config := v
+			if err := checkAppConfig(&config); err != nil {
+				log.Printf("app %s in config file is bad, ignore it: %s", k, err)
+				continue
+			}
+			if _, err := breaker.buildApp(k, &config); err != nil {
+				log.Printf("failed to build app %s from config file, ignore it: %s", k, err)
+			}
 		}
 	} else {
 		log.Printf("failed to unmarshal config file %s because %s", *configPath, err)
@@ -230,6 +552,14 @@ func configIndexHandler(w http.ResponseWriter, r *http.Request) {
 func configManager() {
 	go configKeeper()
 	http.HandleFunc("/app", appHandler)
+	http.HandleFunc("/app/backend", backendHandler)
+	http.HandleFunc("/app/backend/drain", drainHandler)
+	http.HandleFunc("/app/status", appStatusHandler)
+	http.HandleFunc("/app/split", splitHandler)
+	http.HandleFunc("/app/canary", canaryHandler)
+	http.HandleFunc("/app/route", routeHandler)
+	http.HandleFunc("/app/tree", treeHandler)
+	http.HandleFunc("/app/explain", explainHandler)
 	http.HandleFunc("/", configIndexHandler)
 	log.Fatal(http.ListenAndServe(*configAddr, nil))
 }
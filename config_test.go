@@ -2,9 +2,11 @@ package main
 
 import (
 	"bytes"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -62,8 +64,15 @@ func TestCheckAPPConfig(t *testing.T) {
 		t.Errorf("should return error but not")
 	}
 
-	// len(path) != len(methods)
+	// len(backup backends) != len(backup weights)
 	config.Weights = []int{1}
+	config.BackupBackends = []string{"192.168.1.2:80"}
+	if err := checkAppConfig(config); err == nil {
+		t.Errorf("should return error but not")
+	}
+	config.BackupWeights = []int{1}
+
+	// len(path) != len(methods)
 	config.Paths = []string{"/"}
 	if err := checkAppConfig(config); err == nil {
 		t.Errorf("should return error but not")
@@ -157,6 +166,66 @@ func TestCheckAPPConfig(t *testing.T) {
 	}
 }
 
+func TestCheckAPPConfigProblems(t *testing.T) {
+	config := &appConfig{
+		Backends: []string{"192.168.1.1:80"},
+		Paths:    []string{"/user/:name", "/user/:id", "/order/*id/items", "/card"},
+		Methods:  []string{"GET", "GET", "GET", "FETCH"},
+	}
+
+	errs, ok := checkAppConfig(config).(configErrors)
+	if !ok || len(errs) != 5 {
+		t.Fatalf("should return 5 problems but got: %v", errs)
+	}
+	if errs[0] != errNameEmpty || errs[1] != errBackendWeightNotMatch {
+		t.Errorf("name and weights should be problems, but got: %v", errs)
+	}
+	shouldBePathError(t, errs[2], "/user/:id", errWildcardConflict)
+	shouldBePathError(t, errs[3], "/order/*id/items", errCatchAllNotAtEnd)
+	shouldBePathError(t, errs[4], "/card", errBadMethod)
+}
+
+func TestUpdateConfigBadPaths(t *testing.T) {
+	fakeServer := httptest.NewServer(
+		http.HandlerFunc(appHandler),
+	)
+	defer fakeServer.Close()
+
+	running := NewApp(NewRR(), true)
+	breaker.apps["bad.example.com"] = running
+	defer delete(breaker.apps, "bad.example.com")
+
+	config := `{"name":"bad.example.com","backends":["127.0.0.1:80"],"weights":[1],"paths":["/user/:name","/user/:id","/*path<int>"],"methods":["GET","GET","GET"]}`
+	resp, err := http.Post(fakeServer.URL+"/app", "application/json", bytes.NewBufferString(config))
+	if err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("should return 400, but got: %v, %v", resp, err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	lines := strings.Split(string(body), "\n")
+	if len(lines) != 3 || lines[0] != "bad configuration:" ||
+		!strings.Contains(lines[1], "/user/:id") || !strings.Contains(lines[2], "/*path<int>") {
+		t.Errorf("every problem should be listed, but got: %s", body)
+	}
+	if breaker.apps["bad.example.com"] != running {
+		t.Errorf("running app should be unchanged")
+	}
+}
+
+func TestGetAPPFailed(t *testing.T) {
+	// paths conflict, they are not checked
+	config := &appConfig{
+		Name: "failed.example.com", LoadBalanceMethod: LBMWRR,
+		Paths: []string{"/user/:name", "/user/:id"}, Methods: []string{"GET", "GET"},
+		Discovery: []discoveryConfig{{Type: discoveryFile, Path: "/what/backends.json", Interval: 1}},
+	}
+	app, err := getAPP(config)
+	if err == nil || app != nil {
+		t.Errorf("should return error and no app but got: %v, %v", app, err)
+	}
+}
+
 func TestGetBalancer(t *testing.T) {
 	getBalancer(LBMWRR)
 	getBalancer(LBMRR)
@@ -189,16 +258,16 @@ func TestConfigKeeper(t *testing.T) {
 	defer os.Remove(*configPath)
 
 	config := appConfig{
-		"www.example.com",
-		[]string{"192.168.1.1:80"},
-		[]int{1},
-		0.3,
-		false,
-		LBMWRR,
-		[]string{"/"},
-		[]string{"GET"},
-		"",
-		"too many requests",
+		Name:              "www.example.com",
+		Backends:          []string{"192.168.1.1:80"},
+		Weights:           []int{1},
+		Ratio:             0.3,
+		DisableTSR:        false,
+		LoadBalanceMethod: LBMWRR,
+		Paths:             []string{"/"},
+		Methods:           []string{"GET"},
+		FallbackType:      "",
+		FallbackContent:   "too many requests",
 	}
 
 	go configKeeper()
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"regexp"
+)
+
+/*
+constraints of params, a param can be constrained by a type or a regex, e.g. `:id<int>` and
+`:name<[a-z]+\.png>`. a segment which doesn't satisfy the constraint never matches the param.
+*/
+
+const (
+	constraintInt  = "int"  // e.g. 42, -1
+	constraintUUID = "uuid" // e.g. 123e4567-e89b-12d3-a456-426614174000
+)
+
+type constraint struct {
+	name   []byte // name of the param, e.g. `id` of `:id<int>`
+	typ    string // int, uuid, or empty if it's a regex
+	regexp *regexp.Regexp
+}
+
+// newConstraint return constraint of param name, pattern is a type, or a regex otherwise
+func newConstraint(name, pattern []byte) (*constraint, error) {
+	c := &constraint{name: name}
+	switch string(pattern) {
+	case constraintInt, constraintUUID:
+		c.typ = string(pattern)
+	default:
+		// the whole segment should match
+		re, err := regexp.Compile("^(?:" + string(pattern) + ")$")
+		if err != nil {
+			return nil, err
+		}
+		c.regexp = re
+	}
+
+	return c, nil
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isHex(c byte) bool {
+	return isDigit(c) || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+// match return true if the segment satisfies the constraint
+func (c *constraint) match(segment []byte) bool {
+	switch c.typ {
+	case constraintInt:
+		if len(segment) > 0 && segment[0] == '-' {
+			segment = segment[1:]
+		}
+		if len(segment) == 0 {
+			return false
+		}
+		for _, b := range segment {
+			if !isDigit(b) {
+				return false
+			}
+		}
+		return true
+	case constraintUUID:
+		if len(segment) != 36 {
+			return false
+		}
+		for i, b := range segment {
+			if i == 8 || i == 13 || i == 18 || i == 23 {
+				if b != '-' {
+					return false
+				}
+			} else if !isHex(b) {
+				return false
+			}
+		}
+		return true
+	default:
+		return c.regexp.Match(segment)
+	}
+}
+
+// constraintEnd return index after the constraint which starts at path[start], it ends with
+// the first `>` which is followed by `/` or the end of path. -1 if it doesn't end
+func constraintEnd(path []byte, start int) int {
+	for i := start + 1; i < len(path); i++ {
+		if path[i] == '>' && (i+1 == len(path) || path[i+1] == '/') {
+			return i + 1
+		}
+	}
+
+	return -1
+}
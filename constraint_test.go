@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestConstraintMatch(t *testing.T) {
+	expects := []struct {
+		pattern string
+		segment string
+		match   bool
+	}{
+		{"int", "42", true},
+		{"int", "-1", true},
+		{"int", "-", false},
+		{"int", "", false},
+		{"int", "4a", false},
+		{"uuid", "123e4567-e89b-12d3-a456-426614174000", true},
+		{"uuid", "123E4567-E89B-12D3-A456-426614174000", true},
+		{"uuid", "123e4567e89b-12d3-a456-426614174000-", false},
+		{"uuid", "123e4567-e89b-12d3-a456-42661417400g", false},
+		{`[a-z]+\.png`, "cat.png", true},
+		{`[a-z]+\.png`, "cat.png.jpg", false},
+		{`[a-z]+|[0-9]+`, "cat1", false},
+	}
+	for _, e := range expects {
+		c, err := newConstraint([]byte("name"), []byte(e.pattern))
+		if err != nil {
+			t.Fatalf("should not return error but got: %s", err)
+		}
+		if match := c.match([]byte(e.segment)); match != e.match {
+			t.Errorf("%s should match %s? %t, but got: %t", e.segment, e.pattern, e.match, match)
+		}
+	}
+
+	if _, err := newConstraint([]byte("name"), []byte("[a-z")); err == nil {
+		t.Errorf("should return error but not")
+	}
+}
+
+func TestConstraintEnd(t *testing.T) {
+	expects := []struct {
+		path string
+		end  int
+	}{
+		{"/:id<int>", 9},
+		{"/:id<int>/cards", 9},
+		{"/:id<[^/]+>/cards", 11},
+		{"/:id<a>b>", 9},
+		{"/:id<int", -1},
+		{"/:id<int>cards", -1},
+	}
+	for _, e := range expects {
+		if end := constraintEnd([]byte(e.path), 4); end != e.end {
+			t.Errorf("constraint of %s should end at %d, but got: %d", e.path, e.end, end)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+/*
+default route, requests which match no route are proxied by it instead of being answered with
+404, so only hot endpoints have to be listed in paths. like other routes, it has a ring of status
+and the circuit breaker, and it may have its own backends, rules and rewriting with path `*`.
+*/
+
+// defaultRoutePath is the path of the default route, it's never a path of radix tree
+const defaultRoutePath = "*"
+
+// SetDefaultRoute let requests which match no route be proxied by the default route, or be
+// answered with 404 if enabled is false. it should be called before the application serves
+func (a *Application) SetDefaultRoute(enabled bool) {
+	if !enabled {
+		a.defaultRoute = nil
+		delete(a.routes, defaultRoutePath)
+		return
+	}
+	if a.defaultRoute != nil {
+		return
+	}
+
+	// every method is allowed
+	leaf := &node{
+		path: []byte(defaultRoutePath), isLeaf: true, methods: ^NONE,
+		status: StatusRing(), route: newRoute([]byte(defaultRoutePath)),
+	}
+	leaf.route.rules = append(leaf.route.rules, a.rules...)
+	a.routes[defaultRoutePath] = leaf.route
+	a.defaultRoute = leaf
+}
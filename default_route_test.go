@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestDefaultRoute(t *testing.T) {
+	backend, stop := inmemoryBackend(t, func(ctx *fasthttp.RequestCtx) {
+		ctx.Write(ctx.Request.Header.Peek("X-Guard-Route"))
+	})
+	defer stop()
+
+	a := NewApp(NewRR(backend), true)
+	a.AddRoute("/hot", "GET")
+	a.routeHeader = "X-Guard-Route"
+
+	serve := func(method, path string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(method)
+		ctx.Request.SetRequestURI("http://example.com" + path)
+		a.ServeHTTP(ctx)
+		return ctx
+	}
+
+	// disabled by default
+	if code := serve("GET", "/cold").Response.StatusCode(); code != fasthttp.StatusNotFound {
+		t.Errorf("should return 404 but got: %d", code)
+	}
+
+	a.SetDefaultRoute(true)
+	for _, method := range []string{"GET", "POST", "DELETE"} {
+		ctx := serve(method, "/cold/a/b")
+		if body := string(ctx.Response.Body()); ctx.Response.StatusCode() != fasthttp.StatusOK || body != defaultRoutePath {
+			t.Errorf("%s should be proxied by the default route but got: %d, %s", method, ctx.Response.StatusCode(), body)
+		}
+	}
+	if body := string(serve("GET", "/hot").Response.Body()); body != "/hot" {
+		t.Errorf("/hot should be proxied by its route but got: %s", body)
+	}
+	// method of a matched route is still checked
+	if code := serve("POST", "/hot").Response.StatusCode(); code != fasthttp.StatusMethodNotAllowed {
+		t.Errorf("should return 405 but got: %d", code)
+	}
+
+	// default route has its own ring of status
+	if requests, _, _, _, _ := a.defaultRoute.query(); requests != 3 {
+		t.Errorf("default route should have 3 requests but got: %d", requests)
+	}
+	n, _, _ := a.tree().byPath([]byte("/hot"))
+	if requests, _, _, _, _ := n.query(); requests != 1 {
+		t.Errorf("/hot should have 1 request but got: %d", requests)
+	}
+
+	// and its own breaker
+	for i := 0; i < 10; i++ {
+		a.defaultRoute.incr(fasthttp.StatusBadGateway)
+	}
+	if code := serve("GET", "/cold").Response.StatusCode(); code != fasthttp.StatusTooManyRequests {
+		t.Errorf("should return 429 but got: %d", code)
+	}
+	if code := serve("GET", "/hot").Response.StatusCode(); code != fasthttp.StatusOK {
+		t.Errorf("should return 200 but got: %d", code)
+	}
+
+	a.SetDefaultRoute(false)
+	if code := serve("GET", "/cold").Response.StatusCode(); code != fasthttp.StatusNotFound {
+		t.Errorf("should return 404 but got: %d", code)
+	}
+	if _, exist := a.routes[defaultRoutePath]; exist {
+		t.Errorf("default route should be removed")
+	}
+}
+
+func TestDefaultRouteConfig(t *testing.T) {
+	backend, stop := inmemoryBackend(t, func(ctx *fasthttp.RequestCtx) {
+		ctx.Write(ctx.RequestURI())
+	})
+	defer stop()
+
+	config := &appConfig{
+		Name: "www.example.com", Paths: []string{"/hot"}, Methods: []string{"GET"},
+		Routes: []routeConfig{{Path: defaultRoutePath, StripPrefix: "/legacy"}},
+	}
+	if err := checkAppConfig(config); err != errRuleRouteNotFound {
+		t.Errorf("should return %s but got: %v", errRuleRouteNotFound, err)
+	}
+
+	config.DefaultRoute = true
+	if err := checkAppConfig(config); err != nil {
+		t.Fatalf("should not return error but got: %s", err)
+	}
+	a, err := getAPP(config)
+	if err != nil {
+		t.Fatalf("should not return error but got: %s", err)
+	}
+	defer a.Close()
+	a.balancer = NewRR(backend)
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("http://example.com/legacy/users?id=1")
+	a.ServeHTTP(ctx)
+	if body := string(ctx.Response.Body()); body != "/users?id=1" {
+		t.Errorf("default route should rewrite path but got: %s", body)
+	}
+}
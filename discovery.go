@@ -0,0 +1,297 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+service discovery, backends of an application can be found by discoverers besides the
+`backends` in configuration. every discoverer runs in its own goroutine, and the backends
+it finds are applied to the running application, just like `AddBackend` and `RemoveBackend`
+do. backends found by discoverers are not written back to configuration.
+*/
+
+const (
+	discoveryDNS    = "dns"
+	discoveryFile   = "file"
+	discoveryConsul = "consul"
+
+	dnsRecordA   = "a" // A and AAAA records
+	dnsRecordSRV = "srv"
+
+	defaultDiscoveryInterval     = 30 // seconds
+	defaultFileDiscoveryInterval = 5  // seconds, only modification time is checked
+
+	// discoverers wait at least this long between two discoveries
+	minDiscoveryWait = time.Second
+)
+
+var (
+	errBadDiscoveryType     = errors.New("bad discovery type, only dns, file, consul are support now")
+	errBadDiscoveryInterval = errors.New("interval of discovery should not be negative")
+	errDNSNameEmpty         = errors.New("name of dns discovery is required")
+	errBadDNSRecord         = errors.New("bad record of dns discovery, only a, srv are support now")
+	errBadDNSPort           = errors.New("port of dns discovery should be in [1, 65535]")
+	errDiscoveryPathEmpty   = errors.New("path of file discovery is required")
+	errConsulAddressEmpty   = errors.New("address of consul discovery is required")
+	errConsulServiceEmpty   = errors.New("name of consul discovery is required")
+)
+
+type discoveryConfig struct {
+	Type     string `json:"type"`     // dns, file, consul
+	Interval int64  `json:"interval"` // in seconds, how often to refresh backends
+
+	// dns, A/AAAA records are re-resolved when they expire, or every interval seconds
+	Name     string `json:"name,omitempty"`     // e.g. api.example.com, _http._tcp.example.com for SRV
+	Record   string `json:"record,omitempty"`   // a or srv
+	Port     int    `json:"port,omitempty"`     // port of backends, A/AAAA records only
+	Weight   int    `json:"weight,omitempty"`   // weight of backends, A/AAAA records and consul instances without weights
+	Resolver string `json:"resolver,omitempty"` // e.g. 127.0.0.1:53, the first nameserver in /etc/resolv.conf by default
+
+	// file, a JSON list of backends, e.g. [{"backend": "192.168.1.1:80", "weight": 5, "backup": false}]
+	Path string `json:"path,omitempty"`
+
+	// consul, healthy instances of service `name` are backends, interval is the max time a
+	// blocking query waits for changes
+	Address    string   `json:"address,omitempty"`    // e.g. http://127.0.0.1:8500
+	Tags       []string `json:"tags,omitempty"`       // instances should have all of them
+	Datacenter string   `json:"datacenter,omitempty"` // datacenter of the agent by default
+}
+
+func checkDiscoveryConfig(c *discoveryConfig) error {
+	if c.Interval < 0 {
+		return errBadDiscoveryInterval
+	}
+
+	switch c.Type {
+	case discoveryDNS:
+		if c.Interval == 0 {
+			c.Interval = defaultDiscoveryInterval
+		}
+		if c.Name == "" {
+			return errDNSNameEmpty
+		}
+
+		switch c.Record {
+		case "", dnsRecordA:
+			c.Record = dnsRecordA
+			if c.Port <= 0 || c.Port > 65535 {
+				return errBadDNSPort
+			}
+			if c.Weight < 0 {
+				return errBadWeight
+			}
+			if c.Weight == 0 {
+				c.Weight = 1
+			}
+		case dnsRecordSRV:
+		default:
+			return errBadDNSRecord
+		}
+
+		if c.Resolver == "" {
+			c.Resolver = defaultNameserver()
+		}
+	case discoveryFile:
+		if c.Interval == 0 {
+			c.Interval = defaultFileDiscoveryInterval
+		}
+		if c.Path == "" {
+			return errDiscoveryPathEmpty
+		}
+	case discoveryConsul:
+		if c.Interval == 0 {
+			c.Interval = defaultDiscoveryInterval
+		}
+		if c.Address == "" {
+			return errConsulAddressEmpty
+		}
+		if c.Name == "" {
+			return errConsulServiceEmpty
+		}
+		if c.Weight < 0 {
+			return errBadWeight
+		}
+		if c.Weight == 0 {
+			c.Weight = 1
+		}
+	default:
+		return errBadDiscoveryType
+	}
+
+	return nil
+}
+
+// target is a backend found by discoverer
+type target struct {
+	URL    string
+	Weight int
+	Backup bool
+	Zone   string
+}
+
+// discoverer return backends it found, and how long they are valid, 0 means unknown
+type discoverer interface {
+	discover() ([]target, time.Duration, error)
+}
+
+func newDiscoverer(c *discoveryConfig) discoverer {
+	switch c.Type {
+	case discoveryDNS:
+		return &dnsDiscoverer{*c}
+	case discoveryFile:
+		return &fileDiscoverer{config: *c}
+	case discoveryConsul:
+		return newConsulDiscoverer(c)
+	default:
+		log.Panicf("bad discovery type: %s", c.Type)
+		return nil // never here
+	}
+}
+
+// dnsDiscoverer turns every address of a name into a backend
+type dnsDiscoverer struct {
+	config discoveryConfig
+}
+
+func (d *dnsDiscoverer) discover() ([]target, time.Duration, error) {
+	targets := []target{}
+
+	if d.config.Record != dnsRecordSRV {
+		ips, ttl, err := lookupIP(d.config.Resolver, d.config.Name)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		for _, ip := range ips {
+			url := net.JoinHostPort(ip.String(), strconv.Itoa(d.config.Port))
+			targets = append(targets, target{URL: url, Weight: d.config.Weight})
+		}
+
+		return targets, time.Duration(ttl) * time.Second, nil
+	}
+
+	srvs, addrs, ttl, err := lookupSRV(d.config.Resolver, d.config.Name)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// records with the lowest priority are primary backends, the others are backup
+	priority := srvs[0].priority
+	for _, srv := range srvs {
+		if srv.priority < priority {
+			priority = srv.priority
+		}
+	}
+
+	for _, srv := range srvs {
+		// weight 0 means the record should be rarely selected, but a backend with weight 0
+		// will never be selected by WRR
+		weight := int(srv.weight)
+		if weight == 0 {
+			weight = 1
+		}
+
+		for _, ip := range addrs[strings.ToLower(srv.target)] {
+			url := net.JoinHostPort(ip.String(), strconv.Itoa(int(srv.port)))
+			targets = append(targets, target{URL: url, Weight: weight, Backup: srv.priority != priority})
+		}
+	}
+
+	return targets, time.Duration(ttl) * time.Second, nil
+}
+
+// startDiscovery runs discoverer until the application is closed
+func (a *Application) startDiscovery(source string, c *discoveryConfig) {
+	d := newDiscoverer(c)
+	interval := time.Duration(c.Interval) * time.Second
+
+	go func() {
+		initial := true
+		for {
+			targets, ttl, err := d.discover()
+			if err != nil {
+				log.Printf("failed to discover backends from %s: %s, keep the last ones", source, err)
+			} else {
+				a.syncBackends(source, targets, initial)
+				initial = false
+			}
+
+			// refresh when the result expires, but at least once per interval
+			wait := interval
+			if err == nil && ttl > 0 && ttl < wait {
+				wait = ttl
+			}
+			if wait < minDiscoveryWait {
+				wait = minDiscoveryWait
+			}
+
+			select {
+			case <-a.stop:
+				return
+			case <-time.After(wait):
+			}
+		}
+	}()
+}
+
+// syncBackends replace backends found by the given source with targets, backends which
+// are still there keep their connections and stats, new ones will slow start unless
+// they're the initial ones.
+func (a *Application) syncBackends(source string, targets []target, initial bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	current := a.balancer.Backends()
+	backends := make([]Backend, 0, len(current)+len(targets))
+	old := map[string]Backend{}
+	for _, b := range current {
+		if b.source == source {
+			old[b.URL] = b
+		} else {
+			backends = append(backends, b)
+		}
+	}
+
+	changed := false
+	now := CoarseTimeNow().Unix()
+	seen := map[string]bool{}
+	for _, t := range targets {
+		if seen[t.URL] {
+			continue
+		}
+		seen[t.URL] = true
+
+		b, exist := old[t.URL]
+		if exist {
+			delete(old, t.URL)
+			changed = changed || b.Weight != t.Weight || b.Backup != t.Backup || b.Zone != t.Zone
+		} else {
+			b = NewBackend(t.URL, t.Weight)
+			if !initial {
+				b.stats.addedAt = now
+			}
+			b.source = source
+			a.watch(b)
+			changed = true
+		}
+
+		b.Weight, b.Backup, b.Zone = t.Weight, t.Backup, t.Zone
+		backends = append(backends, b)
+	}
+
+	for _, b := range old {
+		a.detector.unwatch(b)
+		changed = true
+	}
+
+	if changed {
+		a.balancer.Update(backends...)
+		log.Printf("backends from %s changed, %d backends now", source, len(seen))
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errConsulBadIndex = errors.New("bad X-Consul-Index in response of consul")
+)
+
+// consulEntry is an entry of /v1/health/service/:service, only fields we need are here
+type consulEntry struct {
+	Node struct {
+		Address string `json:"Address"`
+	} `json:"Node"`
+	Service struct {
+		Address string   `json:"Address"`
+		Port    int      `json:"Port"`
+		Tags    []string `json:"Tags"`
+		Weights struct {
+			Passing int `json:"Passing"`
+		} `json:"Weights"`
+	} `json:"Service"`
+}
+
+// consulDiscoverer long polls health API of consul by blocking queries, the query returns
+// when instances of the service change, or `wait` is over.
+type consulDiscoverer struct {
+	config discoveryConfig
+	client *http.Client
+	wait   time.Duration
+	index  uint64 // X-Consul-Index of the last response
+}
+
+func newConsulDiscoverer(c *discoveryConfig) *consulDiscoverer {
+	wait := time.Duration(c.Interval) * time.Second
+
+	// consul adds a random jitter up to wait/16 to the wait time
+	return &consulDiscoverer{
+		config: *c,
+		client: &http.Client{Timeout: wait + wait/16 + 5*time.Second},
+		wait:   wait,
+	}
+}
+
+func (d *consulDiscoverer) queryURL() string {
+	query := url.Values{}
+	query.Set("passing", "1")
+	query.Set("index", strconv.FormatUint(d.index, 10))
+	query.Set("wait", strconv.FormatInt(int64(d.wait/time.Second), 10)+"s")
+	for _, tag := range d.config.Tags {
+		query.Add("tag", tag)
+	}
+	if d.config.Datacenter != "" {
+		query.Set("dc", d.config.Datacenter)
+	}
+
+	return strings.TrimSuffix(d.config.Address, "/") + "/v1/health/service/" + url.PathEscape(d.config.Name) + "?" + query.Encode()
+}
+
+func (d *consulDiscoverer) discover() ([]target, time.Duration, error) {
+	resp, err := d.client.Get(d.queryURL())
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("consul returns %d", resp.StatusCode)
+	}
+
+	index, err := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	if err != nil || index == 0 {
+		d.index = 0
+		return nil, 0, errConsulBadIndex
+	}
+
+	entries := []consulEntry{}
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, 0, err
+	}
+
+	// index goes backwards if consul is reset, start over again
+	if index < d.index {
+		index = 0
+	}
+	d.index = index
+
+	targets := make([]target, 0, len(entries))
+	for _, e := range entries {
+		host := e.Service.Address
+		if host == "" {
+			host = e.Node.Address
+		}
+
+		weight := e.Service.Weights.Passing
+		if weight <= 0 {
+			weight = d.config.Weight
+		}
+
+		url := net.JoinHostPort(host, strconv.Itoa(e.Service.Port))
+		targets = append(targets, target{URL: url, Weight: weight})
+	}
+
+	// the next query blocks until something changes, so query again right now
+	return targets, minDiscoveryWait, nil
+}
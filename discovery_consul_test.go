@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeConsul serves health API of consul, a blocking query returns when index changes or
+// wait is over
+type fakeConsul struct {
+	lock    sync.Mutex
+	changed chan struct{} // closed when index changes
+	index   uint64
+	entries []map[string]interface{}
+	queries []string
+}
+
+func newFakeConsul() *fakeConsul {
+	return &fakeConsul{index: 1, changed: make(chan struct{})}
+}
+
+func (c *fakeConsul) set(entries ...map[string]interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.index++
+	c.entries = entries
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.lock.Lock()
+	c.queries = append(c.queries, r.URL.String())
+	index, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+	changed := c.changed
+	blocking := index == c.index
+	c.lock.Unlock()
+
+	if blocking {
+		wait, _ := time.ParseDuration(r.URL.Query().Get("wait"))
+		select {
+		case <-changed:
+		case <-time.After(wait):
+		}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(c.index, 10))
+	json.NewEncoder(w).Encode(c.entries)
+}
+
+func consulInstance(node, address string, port, weight int) map[string]interface{} {
+	return map[string]interface{}{
+		"Node":    map[string]interface{}{"Address": node},
+		"Service": map[string]interface{}{"Address": address, "Port": port, "Weights": map[string]int{"Passing": weight}},
+	}
+}
+
+func TestConsulDiscoverer(t *testing.T) {
+	consul := newFakeConsul()
+	consul.set(consulInstance("10.0.0.1", "", 8080, 0), consulInstance("10.0.0.2", "10.0.1.2", 8081, 3))
+	server := httptest.NewServer(consul)
+	defer server.Close()
+
+	c := &discoveryConfig{Type: discoveryConsul, Address: server.URL + "/", Name: "api", Tags: []string{"v1", "http"}, Datacenter: "dc1"}
+	if err := checkDiscoveryConfig(c); err != nil || c.Interval != defaultDiscoveryInterval || c.Weight != 1 {
+		t.Fatalf("should set default values, but got: %+v, %s", c, err)
+	}
+	d := newDiscoverer(c)
+
+	targets, _, err := d.discover()
+	expected := []target{{"10.0.0.1:8080", 1, false, ""}, {"10.0.1.2:8081", 3, false, ""}}
+	if err != nil || len(targets) != len(expected) || targets[0] != expected[0] || targets[1] != expected[1] {
+		t.Errorf("targets should be %+v, but got: %+v, %s", expected, targets, err)
+	}
+	consul.lock.Lock()
+	query := consul.queries[0]
+	consul.lock.Unlock()
+	if query != "/v1/health/service/api?dc=dc1&index=0&passing=1&tag=v1&tag=http&wait=30s" {
+		t.Errorf("bad query: %s", query)
+	}
+
+	// the next query blocks until instances change
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		consul.set(consulInstance("10.0.0.3", "", 8080, 1))
+	}()
+	targets, _, err = d.discover()
+	if err != nil || len(targets) != 1 || targets[0].URL != "10.0.0.3:8080" {
+		t.Errorf("failed to discover changes: %+v, %s", targets, err)
+	}
+	if d.(*consulDiscoverer).index != 3 {
+		t.Errorf("index should be 3, but got: %d", d.(*consulDiscoverer).index)
+	}
+
+	expects := []struct {
+		config discoveryConfig
+		err    error
+	}{
+		{discoveryConfig{Type: discoveryConsul, Name: "api"}, errConsulAddressEmpty},
+		{discoveryConfig{Type: discoveryConsul, Address: server.URL}, errConsulServiceEmpty},
+		{discoveryConfig{Type: discoveryConsul, Address: server.URL, Name: "api", Weight: -1}, errBadWeight},
+	}
+	for i, e := range expects {
+		if err := checkDiscoveryConfig(&e.config); err != e.err {
+			t.Errorf("the %dth config should return %v but got: %v", i, e.err, err)
+		}
+	}
+}
+
+func TestConsulDiscovererBadResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("tag") == "broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`[]`)) // without index
+	}))
+	defer server.Close()
+
+	for _, tag := range []string{"broken", "noindex"} {
+		d := newConsulDiscoverer(&discoveryConfig{Type: discoveryConsul, Address: server.URL, Name: "api", Tags: []string{tag}, Interval: 1})
+		if _, _, err := d.discover(); err == nil {
+			t.Errorf("should return error but not")
+		}
+	}
+}
+
+func TestStartConsulDiscovery(t *testing.T) {
+	consul := newFakeConsul()
+	consul.set(consulInstance("10.0.0.1", "", 8080, 1))
+	server := httptest.NewServer(consul)
+	defer server.Close()
+
+	a := NewApp(NewRR(), true)
+	defer a.Close()
+	c := &discoveryConfig{Type: discoveryConsul, Address: server.URL, Name: "api", Interval: 1}
+	checkDiscoveryConfig(c)
+	a.startDiscovery("consul", c)
+
+	waitBackends := func(n int) []Backend {
+		for i := 0; i < 150 && len(a.balancer.Backends()) != n; i++ {
+			time.Sleep(20 * time.Millisecond)
+		}
+		return a.balancer.Backends()
+	}
+
+	if backends := waitBackends(1); len(backends) != 1 || backends[0].URL != "10.0.0.1:8080" {
+		t.Fatalf("backends should be discovered, but got: %+v", backends)
+	}
+
+	consul.set(consulInstance("10.0.0.1", "", 8080, 1), consulInstance("10.0.0.2", "", 8080, 1))
+	if backends := waitBackends(2); len(backends) != 2 {
+		t.Errorf("new instance should be added, but got: %+v", backends)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+var (
+	errDiscoveryFileEmpty = errors.New("no backend found in discovery file")
+)
+
+// fileDiscoverer reads backends from a JSON file, which is read again only if it's modified
+type fileDiscoverer struct {
+	config discoveryConfig
+
+	modTime time.Time
+	size    int64
+	targets []target
+	err     error
+}
+
+// parseTargets parse backends in the file, the file is rejected as a whole if any of them is bad
+func parseTargets(content []byte) ([]target, error) {
+	backends := []backendConfig{}
+	if err := json.Unmarshal(content, &backends); err != nil {
+		return nil, err
+	}
+	if len(backends) == 0 {
+		return nil, errDiscoveryFileEmpty
+	}
+
+	targets := make([]target, 0, len(backends))
+	for _, b := range backends {
+		if b.Backend == "" {
+			return nil, errBackendEmpty
+		}
+		if b.Weight < 0 {
+			return nil, errBadWeight
+		}
+		if b.Weight == 0 {
+			b.Weight = 1
+		}
+		targets = append(targets, target{URL: b.Backend, Weight: b.Weight, Backup: b.Backup, Zone: b.Zone})
+	}
+
+	return targets, nil
+}
+
+func (d *fileDiscoverer) discover() ([]target, time.Duration, error) {
+	info, err := os.Stat(d.config.Path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// the same file, return the last result
+	if info.ModTime().Equal(d.modTime) && info.Size() == d.size {
+		return d.targets, 0, d.err
+	}
+
+	content, err := ioutil.ReadFile(d.config.Path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	d.modTime, d.size = info.ModTime(), info.Size()
+	d.targets, d.err = parseTargets(content)
+	return d.targets, 0, d.err
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseTargets(t *testing.T) {
+	targets, err := parseTargets([]byte(`[{"backend":"192.168.1.1:80","weight":5},{"backend":"192.168.1.2:80","backup":true}]`))
+	if err != nil || len(targets) != 2 {
+		t.Fatalf("failed to parse targets: %+v, %s", targets, err)
+	}
+	if targets[0] != (target{"192.168.1.1:80", 5, false, ""}) || targets[1] != (target{"192.168.1.2:80", 1, true, ""}) {
+		t.Errorf("targets are wrong: %+v", targets)
+	}
+
+	for _, content := range []string{
+		``,
+		`[]`,
+		`[{"backend":"192.168.1.1:80"`,
+		`[{"weight":1}]`,
+		`[{"backend":"192.168.1.1:80","weight":-1}]`,
+	} {
+		if _, err := parseTargets([]byte(content)); err == nil {
+			t.Errorf("should return error for %s but not", content)
+		}
+	}
+}
+
+func TestFileDiscoverer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "guard")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "backends.json")
+
+	c := &discoveryConfig{Type: discoveryFile, Path: path}
+	if err := checkDiscoveryConfig(c); err != nil || c.Interval != defaultFileDiscoveryInterval {
+		t.Errorf("should set default interval but got: %+v, %s", c, err)
+	}
+	d := newDiscoverer(c)
+
+	if _, _, err := d.discover(); err == nil {
+		t.Errorf("should return error if file does not exist")
+	}
+
+	ioutil.WriteFile(path, []byte(`[{"backend":"192.168.1.1:80","weight":1}]`), 0644)
+	if targets, _, err := d.discover(); err != nil || len(targets) != 1 {
+		t.Errorf("failed to discover from file: %+v, %s", targets, err)
+	}
+
+	// bad contents are rejected
+	ioutil.WriteFile(path, []byte(`[{"backend":"192.168.1.1:80","weight":1},`), 0644)
+	if _, _, err := d.discover(); err == nil {
+		t.Errorf("should return error for bad file")
+	}
+
+	ioutil.WriteFile(path, []byte(`[{"backend":"192.168.1.1:80"},{"backend":"192.168.1.2:80"}]`), 0644)
+	if targets, _, err := d.discover(); err != nil || len(targets) != 2 {
+		t.Errorf("failed to discover from file: %+v, %s", targets, err)
+	}
+}
+
+func TestStartFileDiscovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "guard")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "backends.json")
+	ioutil.WriteFile(path, []byte(`[{"backend":"192.168.1.1:80"},{"backend":"192.168.1.2:80","backup":true}]`), 0644)
+
+	config := &appConfig{
+		Name: "file.example.com", LoadBalanceMethod: LBMWRR,
+		Discovery: []discoveryConfig{{Type: discoveryFile, Path: path, Interval: 1}},
+	}
+	if err := checkAppConfig(config); err != nil {
+		t.Fatalf("should not return error but got: %s", err)
+	}
+	a, _ := getAPP(config)
+	defer a.Close()
+
+	for i := 0; i < 50 && len(a.balancer.Backends()) != 2; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if b, found := a.balancer.Select(); !found || b.URL != "192.168.1.1:80" {
+		t.Errorf("should select backend from file, but got: %+v, %t", b, found)
+	}
+
+	// the last good set is kept
+	ioutil.WriteFile(path, []byte(`what`), 0644)
+	time.Sleep(1500 * time.Millisecond)
+	if n := len(a.balancer.Backends()); n != 2 {
+		t.Errorf("should keep the last good backends, but got %d backends", n)
+	}
+}
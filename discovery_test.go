@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckDiscoveryConfig(t *testing.T) {
+	c := &discoveryConfig{Type: discoveryDNS, Name: "api.example.com", Port: 80}
+	if err := checkDiscoveryConfig(c); err != nil {
+		t.Errorf("should not return error but got: %s", err)
+	}
+	if c.Interval != defaultDiscoveryInterval || c.Record != dnsRecordA || c.Weight != 1 || c.Resolver == "" {
+		t.Errorf("discovery config should be set to default, but got: %+v", c)
+	}
+
+	expects := []struct {
+		config discoveryConfig
+		err    error
+	}{
+		{discoveryConfig{Type: "what", Name: "api.example.com", Port: 80}, errBadDiscoveryType},
+		{discoveryConfig{Type: discoveryDNS, Name: "api.example.com", Port: 80, Interval: -1}, errBadDiscoveryInterval},
+		{discoveryConfig{Type: discoveryDNS, Port: 80}, errDNSNameEmpty},
+		{discoveryConfig{Type: discoveryDNS, Name: "api.example.com", Record: "mx"}, errBadDNSRecord},
+		{discoveryConfig{Type: discoveryDNS, Name: "api.example.com"}, errBadDNSPort},
+		{discoveryConfig{Type: discoveryDNS, Name: "api.example.com", Port: 80, Weight: -1}, errBadWeight},
+		{discoveryConfig{Type: discoveryDNS, Name: "_http._tcp.example.com", Record: dnsRecordSRV}, nil},
+	}
+	for i, e := range expects {
+		if err := checkDiscoveryConfig(&e.config); err != e.err {
+			t.Errorf("the %dth config should return %v but got: %v", i, e.err, err)
+		}
+	}
+}
+
+func TestSyncBackends(t *testing.T) {
+	static := NewBackend("192.168.1.1:80", 1)
+
+	for _, method := range []string{LBMWRR, LBMRR, LBMRandom} {
+		a := NewApp(getBalancer(method, static), true)
+		a.slowStart = &slowStartConfig{Duration: 100, MinWeightPercent: 10, Aggression: 1}
+
+		a.syncBackends("dns", []target{{"10.0.0.1:80", 1, false, ""}, {"10.0.0.2:80", 1, false, ""}}, true)
+		backends := a.balancer.Backends()
+		if len(backends) != 3 || backends[1].source != "dns" || backends[1].stats.addedAt != 0 {
+			t.Fatalf("%s: initial backends should be added without slow start, but got: %+v", method, backends)
+		}
+		kept := backends[2].stats
+
+		a.syncBackends("dns", []target{{"10.0.0.2:80", 2, false, ""}, {"10.0.0.3:80", 1, true, ""}}, false)
+		backends = a.balancer.Backends()
+		if len(backends) != 3 || backends[0].URL != static.URL {
+			t.Fatalf("%s: static backend should be kept, but got: %+v", method, backends)
+		}
+		if backends[1].URL != "10.0.0.2:80" || backends[1].stats != kept || backends[1].Weight != 2 {
+			t.Errorf("%s: existing backend should keep its stats, but got: %+v", method, backends[1])
+		}
+		if backends[2].URL != "10.0.0.3:80" || !backends[2].Backup || backends[2].stats.addedAt == 0 {
+			t.Errorf("%s: new backend should be added with slow start, but got: %+v", method, backends[2])
+		}
+
+		// backends of other sources are not touched
+		a.syncBackends("file", []target{}, false)
+		if n := len(a.balancer.Backends()); n != 3 {
+			t.Errorf("%s: should have 3 backends, but got: %d", method, n)
+		}
+		a.syncBackends("dns", []target{}, false)
+		if n := len(a.balancer.Backends()); n != 1 {
+			t.Errorf("%s: should have 1 backend, but got: %d", method, n)
+		}
+	}
+}
+
+func TestStartDiscovery(t *testing.T) {
+	records := []stubRecord{
+		{"api.example.com", dnsTypeA, 1, []byte{10, 0, 0, 1}},
+	}
+	server, stop := startStubDNS(t, &records, false)
+	defer stop()
+
+	a := NewApp(NewRR(NewBackend("192.168.1.1:80", 1)), true)
+	defer a.Close()
+
+	c := &discoveryConfig{Type: discoveryDNS, Name: "api.example.com", Port: 8080, Resolver: server}
+	checkDiscoveryConfig(c)
+	a.startDiscovery("dns", c)
+
+	for i := 0; i < 50; i++ {
+		if len(a.balancer.Backends()) == 2 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	backends := a.balancer.Backends()
+	if len(backends) != 2 || backends[1].URL != "10.0.0.1:8080" {
+		t.Errorf("backends should be discovered, but got: %+v", backends)
+	}
+}
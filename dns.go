@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+a minimal DNS client, it only knows A, AAAA and SRV records. the resolver of standard
+library does not tell us TTL of records, but we want to re-resolve names when they expire.
+*/
+
+const (
+	dnsTypeA    uint16 = 1
+	dnsTypeAAAA uint16 = 28
+	dnsTypeSRV  uint16 = 33
+	dnsClassIN  uint16 = 1
+
+	dnsTimeout = 3 * time.Second
+)
+
+var (
+	errDNSBadResponse = errors.New("bad dns response")
+	errDNSNameTooLong = errors.New("dns name is too long")
+	errDNSNoRecord    = errors.New("no dns record found")
+)
+
+// dnsRecord is an answer of a query, only fields of the record's type are set
+type dnsRecord struct {
+	name     string
+	rrType   uint16
+	ttl      uint32
+	ip       net.IP // A or AAAA
+	priority uint16 // SRV
+	weight   uint16 // SRV
+	port     uint16 // SRV
+	target   string // SRV
+}
+
+// defaultNameserver return the first nameserver in /etc/resolv.conf
+func defaultNameserver() string {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return "127.0.0.1:53"
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53")
+		}
+	}
+
+	return "127.0.0.1:53"
+}
+
+func packDNSQuery(id uint16, name string, qtype uint16) ([]byte, error) {
+	msg := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], 0x0100) // recursion desired
+	binary.BigEndian.PutUint16(msg[4:], 1)      // one question
+
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, errDNSNameTooLong
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0, byte(qtype>>8), byte(qtype), byte(dnsClassIN>>8), byte(dnsClassIN))
+
+	return msg, nil
+}
+
+// readDNSName read a name which may be compressed, return the name and offset after it
+func readDNSName(msg []byte, offset int) (string, int, error) {
+	labels := []string{}
+	end := -1 // offset after the name, set when the first pointer is met
+
+	for jumps := 0; ; {
+		if offset >= len(msg) {
+			return "", 0, errDNSBadResponse
+		}
+
+		length := int(msg[offset])
+		switch {
+		case length == 0:
+			if end < 0 {
+				end = offset + 1
+			}
+			return strings.Join(labels, "."), end, nil
+		case length&0xC0 == 0xC0: // pointer
+			if offset+1 >= len(msg) || jumps > 10 {
+				return "", 0, errDNSBadResponse
+			}
+			if end < 0 {
+				end = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(msg[offset:]) & 0x3FFF)
+			jumps++
+		default:
+			if offset+1+length > len(msg) {
+				return "", 0, errDNSBadResponse
+			}
+			labels = append(labels, string(msg[offset+1:offset+1+length]))
+			offset += 1 + length
+		}
+	}
+}
+
+// unpackDNSResponse return records in answer and additional section
+func unpackDNSResponse(msg []byte, id uint16) ([]dnsRecord, bool, error) {
+	if len(msg) < 12 || binary.BigEndian.Uint16(msg) != id {
+		return nil, false, errDNSBadResponse
+	}
+
+	flags := binary.BigEndian.Uint16(msg[2:])
+	truncated := flags&0x0200 != 0
+	if rcode := flags & 0x000F; rcode != 0 {
+		return nil, truncated, errors.New("dns query failed with rcode " + strconv.Itoa(int(rcode)))
+	}
+
+	questions := int(binary.BigEndian.Uint16(msg[4:]))
+	answers := int(binary.BigEndian.Uint16(msg[6:]))
+	authorities := int(binary.BigEndian.Uint16(msg[8:]))
+	additionals := int(binary.BigEndian.Uint16(msg[10:]))
+
+	offset := 12
+	var err error
+	for i := 0; i < questions; i++ {
+		if _, offset, err = readDNSName(msg, offset); err != nil {
+			return nil, truncated, err
+		}
+		offset += 4 // type and class
+	}
+
+	records := []dnsRecord{}
+	for i := 0; i < answers+authorities+additionals; i++ {
+		var r dnsRecord
+		if r.name, offset, err = readDNSName(msg, offset); err != nil {
+			return nil, truncated, err
+		}
+		if offset+10 > len(msg) {
+			return nil, truncated, errDNSBadResponse
+		}
+
+		r.rrType = binary.BigEndian.Uint16(msg[offset:])
+		r.ttl = binary.BigEndian.Uint32(msg[offset+4:])
+		length := int(binary.BigEndian.Uint16(msg[offset+8:]))
+		offset += 10
+		if offset+length > len(msg) {
+			return nil, truncated, errDNSBadResponse
+		}
+		data := msg[offset : offset+length]
+
+		switch r.rrType {
+		case dnsTypeA, dnsTypeAAAA:
+			if len(data) != net.IPv4len && len(data) != net.IPv6len {
+				return nil, truncated, errDNSBadResponse
+			}
+			r.ip = net.IP(append([]byte{}, data...))
+		case dnsTypeSRV:
+			if len(data) < 7 {
+				return nil, truncated, errDNSBadResponse
+			}
+			r.priority = binary.BigEndian.Uint16(data)
+			r.weight = binary.BigEndian.Uint16(data[2:])
+			r.port = binary.BigEndian.Uint16(data[4:])
+			if r.target, _, err = readDNSName(msg, offset+6); err != nil {
+				return nil, truncated, err
+			}
+		}
+		offset += length
+
+		// records in authority section are useless for us
+		if i < answers || i >= answers+authorities {
+			records = append(records, r)
+		}
+	}
+
+	return records, truncated, nil
+}
+
+// dnsExchange send the query to server by UDP, and retry by TCP if the response is truncated
+func dnsExchange(server string, name string, qtype uint16) ([]dnsRecord, error) {
+	id := uint16(rand.Intn(1 << 16))
+	query, err := packDNSQuery(id, name, qtype)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTimeout("udp", server, dnsTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(dnsTimeout))
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	records, truncated, err := unpackDNSResponse(buf[:n], id)
+	if !truncated {
+		return records, err
+	}
+
+	// response is truncated, try again by TCP, message is prefixed with its length
+	tcpConn, err := net.DialTimeout("tcp", server, dnsTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer tcpConn.Close()
+
+	tcpConn.SetDeadline(time.Now().Add(dnsTimeout))
+	if _, err := tcpConn.Write(append([]byte{byte(len(query) >> 8), byte(len(query))}, query...)); err != nil {
+		return nil, err
+	}
+	length := make([]byte, 2)
+	if _, err := io.ReadFull(tcpConn, length); err != nil {
+		return nil, err
+	}
+	buf = make([]byte, binary.BigEndian.Uint16(length))
+	if _, err := io.ReadFull(tcpConn, buf); err != nil {
+		return nil, err
+	}
+
+	records, _, err = unpackDNSResponse(buf, id)
+	return records, err
+}
+
+// lookupIP return A and AAAA records of the name, and the minimum TTL of them
+func lookupIP(server string, name string) ([]net.IP, uint32, error) {
+	ips := []net.IP{}
+	var ttl uint32
+	var lastErr error
+
+	for _, qtype := range []uint16{dnsTypeA, dnsTypeAAAA} {
+		records, err := dnsExchange(server, name, qtype)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		for _, r := range records {
+			if r.rrType != qtype {
+				continue // e.g. CNAME
+			}
+			ips = append(ips, r.ip)
+			if ttl == 0 || r.ttl < ttl {
+				ttl = r.ttl
+			}
+		}
+	}
+
+	if len(ips) == 0 {
+		if lastErr != nil {
+			return nil, 0, lastErr
+		}
+		return nil, 0, errDNSNoRecord
+	}
+
+	return ips, ttl, nil
+}
+
+// lookupSRV return SRV records of the name, addresses of targets(in lower case), and the
+// minimum TTL of them
+func lookupSRV(server string, name string) ([]dnsRecord, map[string][]net.IP, uint32, error) {
+	records, err := dnsExchange(server, name, dnsTypeSRV)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	srvs := []dnsRecord{}
+	addrs := map[string][]net.IP{} // target -> addresses, from additional section
+	var ttl uint32
+	for _, r := range records {
+		switch r.rrType {
+		case dnsTypeSRV:
+			srvs = append(srvs, r)
+			if ttl == 0 || r.ttl < ttl {
+				ttl = r.ttl
+			}
+		case dnsTypeA, dnsTypeAAAA:
+			name := strings.ToLower(r.name)
+			addrs[name] = append(addrs[name], r.ip)
+		}
+	}
+
+	if len(srvs) == 0 {
+		return nil, nil, 0, errDNSNoRecord
+	}
+
+	// resolve targets which are not in additional section
+	for _, srv := range srvs {
+		target := strings.ToLower(srv.target)
+		if _, exist := addrs[target]; exist {
+			continue
+		}
+
+		ips, ipTTL, err := lookupIP(server, srv.target)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		addrs[target] = ips
+		if ipTTL < ttl {
+			ttl = ipTTL
+		}
+	}
+
+	return srvs, addrs, ttl, nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// stubRecord is a record answered by stub DNS server
+type stubRecord struct {
+	name   string
+	rrType uint16
+	ttl    uint32
+	data   []byte
+}
+
+func appendDNSName(msg []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	return append(msg, 0)
+}
+
+// appendStubRecord append the record except its name
+func appendStubRecord(msg []byte, r stubRecord) []byte {
+	msg = append(msg, byte(r.rrType>>8), byte(r.rrType), 0, 1)
+	msg = append(msg, byte(r.ttl>>24), byte(r.ttl>>16), byte(r.ttl>>8), byte(r.ttl))
+	msg = append(msg, byte(len(r.data)>>8), byte(len(r.data)))
+	return append(msg, r.data...)
+}
+
+func stubSRV(priority, weight, port uint16, target string) []byte {
+	data := []byte{byte(priority >> 8), byte(priority), byte(weight >> 8), byte(weight), byte(port >> 8), byte(port)}
+	return appendDNSName(data, target)
+}
+
+// packStubResponse answers the query with records, answers are records whose name and type
+// are the same as the question's, the others are put in additional section
+func packStubResponse(query []byte, records []stubRecord, truncated bool) []byte {
+	name, offset, _ := readDNSName(query, 12)
+	qtype := binary.BigEndian.Uint16(query[offset:])
+
+	answers, additionals := []stubRecord{}, []stubRecord{}
+	for _, r := range records {
+		if strings.EqualFold(r.name, name) && r.rrType == qtype {
+			answers = append(answers, r)
+		} else if qtype == dnsTypeSRV && r.rrType != dnsTypeSRV && !strings.HasPrefix(r.name, "c.") {
+			// leave c.example.com out of additional section, so it's resolved separately
+			additionals = append(additionals, r)
+		}
+	}
+
+	flags := uint16(0x8180)
+	if truncated {
+		flags |= 0x0200
+	}
+
+	msg := make([]byte, 12)
+	copy(msg, query[:2])
+	binary.BigEndian.PutUint16(msg[2:], flags)
+	binary.BigEndian.PutUint16(msg[4:], 1)
+	binary.BigEndian.PutUint16(msg[6:], uint16(len(answers)))
+	binary.BigEndian.PutUint16(msg[10:], uint16(len(additionals)))
+	msg = append(msg, query[12:offset+4]...)
+
+	for _, r := range answers {
+		// use pointer to the question
+		msg = appendStubRecord(append(msg, 0xC0, 12), r)
+	}
+	for _, r := range additionals {
+		msg = appendStubRecord(appendDNSName(msg, r.name), r)
+	}
+
+	return msg
+}
+
+// startStubDNS start a stub DNS server listening UDP and TCP on the same port, responses by
+// UDP are truncated if truncateUDP is true
+func startStubDNS(t *testing.T, records *[]stubRecord, truncateUDP bool) (string, func()) {
+	tcpLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen tcp: %s", err)
+	}
+	udpConn, err := net.ListenPacket("udp", tcpLn.Addr().String())
+	if err != nil {
+		tcpLn.Close()
+		t.Skipf("failed to listen udp on the same port: %s", err)
+	}
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := udpConn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			udpConn.WriteTo(packStubResponse(buf[:n], *records, truncateUDP), addr)
+		}
+	}()
+
+	go func() {
+		for {
+			conn, err := tcpLn.Accept()
+			if err != nil {
+				return
+			}
+
+			length := make([]byte, 2)
+			io.ReadFull(conn, length)
+			query := make([]byte, binary.BigEndian.Uint16(length))
+			io.ReadFull(conn, query)
+			resp := packStubResponse(query, *records, false)
+			conn.Write(append([]byte{byte(len(resp) >> 8), byte(len(resp))}, resp...))
+			conn.Close()
+		}
+	}()
+
+	return tcpLn.Addr().String(), func() {
+		tcpLn.Close()
+		udpConn.Close()
+	}
+}
+
+func TestReadDNSName(t *testing.T) {
+	msg := appendDNSName(make([]byte, 12), "www.example.com")
+	msg = append(msg, 3, 'a', 'p', 'i', 0xC0, 16) // api.example.com, point to "example.com"
+
+	name, offset, err := readDNSName(msg, 12)
+	if err != nil || name != "www.example.com" || offset != 29 {
+		t.Errorf("failed to read name: %s, %d, %s", name, offset, err)
+	}
+
+	name, offset, err = readDNSName(msg, 29)
+	if err != nil || name != "api.example.com" || offset != len(msg) {
+		t.Errorf("failed to read compressed name: %s, %d, %s", name, offset, err)
+	}
+
+	// pointer loop
+	if _, _, err = readDNSName([]byte{0xC0, 0}, 0); err == nil {
+		t.Errorf("should return error but not")
+	}
+	// out of range
+	if _, _, err = readDNSName([]byte{3, 'a'}, 0); err == nil {
+		t.Errorf("should return error but not")
+	}
+}
+
+func TestPackDNSQuery(t *testing.T) {
+	if _, err := packDNSQuery(1, "www..example.com", dnsTypeA); err == nil {
+		t.Errorf("should return error but not")
+	}
+	if _, err := packDNSQuery(1, strings.Repeat("a", 64)+".com", dnsTypeA); err == nil {
+		t.Errorf("should return error but not")
+	}
+}
+
+func TestUnpackDNSResponse(t *testing.T) {
+	query, _ := packDNSQuery(1, "www.example.com", dnsTypeA)
+	resp := packStubResponse(query, []stubRecord{{"www.example.com", dnsTypeA, 60, []byte{10, 0, 0, 1}}}, false)
+
+	if _, _, err := unpackDNSResponse(resp, 2); err != errDNSBadResponse {
+		t.Errorf("should return error because id does not match, but got: %s", err)
+	}
+	if _, _, err := unpackDNSResponse(resp[:len(resp)-2], 1); err != errDNSBadResponse {
+		t.Errorf("should return error because response is truncated, but got: %s", err)
+	}
+
+	resp[3] |= 3 // NXDOMAIN
+	if _, _, err := unpackDNSResponse(resp, 1); err == nil {
+		t.Errorf("should return error because of rcode, but not")
+	}
+}
+
+func TestLookupIP(t *testing.T) {
+	records := []stubRecord{
+		{"api.example.com", dnsTypeA, 60, []byte{10, 0, 0, 1}},
+		{"api.example.com", dnsTypeA, 60, []byte{10, 0, 0, 2}},
+		{"api.example.com", dnsTypeAAAA, 30, net.ParseIP("fd00::1").To16()},
+	}
+
+	for _, truncated := range []bool{false, true} {
+		server, stop := startStubDNS(t, &records, truncated)
+
+		ips, ttl, err := lookupIP(server, "api.example.com")
+		if err != nil || len(ips) != 3 || ttl != 30 {
+			t.Errorf("failed to lookup ip: %+v, %d, %s", ips, ttl, err)
+		}
+		if len(ips) == 3 && (ips[0].String() != "10.0.0.1" || ips[2].String() != "fd00::1") {
+			t.Errorf("failed to lookup ip: %+v", ips)
+		}
+
+		if _, _, err := lookupIP(server, "what.example.com"); err != errDNSNoRecord {
+			t.Errorf("should return %s but got: %s", errDNSNoRecord, err)
+		}
+
+		stop()
+	}
+}
+
+func TestLookupSRV(t *testing.T) {
+	records := []stubRecord{
+		{"_http._tcp.example.com", dnsTypeSRV, 60, stubSRV(10, 5, 8080, "a.example.com")},
+		{"_http._tcp.example.com", dnsTypeSRV, 20, stubSRV(10, 1, 8081, "B.example.com")},
+		{"_http._tcp.example.com", dnsTypeSRV, 60, stubSRV(20, 0, 8082, "c.example.com")},
+		{"a.example.com", dnsTypeA, 60, []byte{10, 0, 0, 1}},
+		{"b.example.com", dnsTypeA, 60, []byte{10, 0, 0, 2}},
+		{"c.example.com", dnsTypeA, 10, []byte{10, 0, 0, 3}},
+	}
+	server, stop := startStubDNS(t, &records, false)
+	defer stop()
+
+	srvs, addrs, ttl, err := lookupSRV(server, "_http._tcp.example.com")
+	if err != nil || len(srvs) != 3 || ttl != 10 {
+		t.Fatalf("failed to lookup srv: %+v, %d, %s", srvs, ttl, err)
+	}
+	if len(addrs["b.example.com"]) != 1 || srvs[1].port != 8081 || srvs[1].weight != 1 || srvs[1].priority != 10 {
+		t.Errorf("failed to lookup srv: %+v, %+v", srvs, addrs)
+	}
+
+	d := &dnsDiscoverer{discoveryConfig{Type: discoveryDNS, Name: "_http._tcp.example.com", Record: dnsRecordSRV, Resolver: server}}
+	targets, _, err := d.discover()
+	expected := []target{
+		{"10.0.0.1:8080", 5, false, ""},
+		{"10.0.0.2:8081", 1, false, ""},
+		{"10.0.0.3:8082", 1, true, ""},
+	}
+	if err != nil || len(targets) != len(expected) {
+		t.Fatalf("failed to discover srv: %+v, %s", targets, err)
+	}
+	for i, e := range expected {
+		if targets[i] != e {
+			t.Errorf("the %dth target should be %+v, but got: %+v", i, e, targets[i])
+		}
+	}
+
+	if _, _, _, err := lookupSRV(server, "_what._tcp.example.com"); err != errDNSNoRecord {
+		t.Errorf("should return %s but got: %s", errDNSNoRecord, err)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/valyala/fasthttp"
+)
+
+/*
+introspection of routing, the radix tree of an app can be dumped, and how a request would be
+served can be explained: which app and route it matches, the params captured, whether it's
+redirected, whether the circuit breaker is open, and a backend which it may be proxied to.
+*/
+
+// treeDump is a node of radix tree in the dump
+type treeDump struct {
+	Path       string     `json:"path"`
+	Type       string     `json:"type"`                 // static, root, param or catchAll
+	Constraint string     `json:"constraint,omitempty"` // e.g. int, or a regex
+	Route      string     `json:"route,omitempty"`      // pattern of the route if it's a leaf
+	Methods    []string   `json:"methods,omitempty"`
+	Ring       bool       `json:"ring"` // it has a ring of status
+	Children   []treeDump `json:"children,omitempty"`
+}
+
+type appTreeDump struct {
+	Tree         treeDump  `json:"tree"`
+	DefaultRoute *treeDump `json:"default_route,omitempty"`
+}
+
+func (t nodeType) String() string {
+	switch t {
+	case static:
+		return "static"
+	case root:
+		return "root"
+	case param:
+		return "param"
+	case catchAll:
+		return "catchAll"
+	default:
+		return "unknown"
+	}
+}
+
+// methodNames return names of methods, e.g. [GET, POST]
+func methodNames(methods HTTPMethod) []string {
+	var names []string
+	for i, name := range methodTableNow().names {
+		if methods&(GET<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// dump return the tree below n
+func (n *node) dump() treeDump {
+	d := treeDump{Path: string(n.path), Type: n.nType.String(), Ring: n.status != nil}
+	if n.constraint != nil {
+		d.Constraint = n.constraint.typ
+		if d.Constraint == "" {
+			d.Constraint = n.constraint.regexp.String()
+		}
+	}
+	if n.isLeaf {
+		d.Methods = methodNames(n.methods)
+		if n.route != nil {
+			d.Route = n.route.path
+		}
+	}
+	for _, child := range n.children {
+		d.Children = append(d.Children, child.dump())
+	}
+
+	return d
+}
+
+// Tree return the dump of radix tree which is serving
+func (a *Application) Tree() appTreeDump {
+	d := appTreeDump{Tree: a.tree().dump()}
+	if a.defaultRoute != nil {
+		defaultRoute := a.defaultRoute.dump()
+		d.DefaultRoute = &defaultRoute
+	}
+
+	return d
+}
+
+type paramDump struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// explanation is how a request would be served
+type explanation struct {
+	App         string      `json:"app,omitempty"`   // empty if no app matches the host
+	Route       string      `json:"route,omitempty"` // pattern of the route, `*` for the default route
+	Params      []paramDump `json:"params,omitempty"`
+	Allow       []string    `json:"allow,omitempty"`      // methods allowed by the route
+	TSR         bool        `json:"tsr"`                  // a route matches if trailing slash is added or removed
+	FixedPath   string      `json:"fixed_path,omitempty"` // the cleaned and case-insensitive matched path
+	Redirect    string      `json:"redirect,omitempty"`
+	Ratio       float64     `json:"ratio"` // ratio of failures of the route
+	BreakerOpen bool        `json:"breaker_open"`
+	Group       string      `json:"group,omitempty"`   // group of backends which is picked
+	Backend     string      `json:"backend,omitempty"` // an available backend which the request may be proxied to
+	Status      int         `json:"status"`            // answer of guard, 0 if it's proxied
+}
+
+// availableBackend return the first available backend of balancer, primary ones first. it's not
+// always the one which Select return, but the state of balancer is unchanged
+func availableBackend(balancer Balancer) (*Backend, bool) {
+	now := CoarseTimeNow().Unix()
+	backends := balancer.Backends()
+	for _, backup := range []bool{false, true} {
+		for i := range backends {
+			if backends[i].Backup == backup && backends[i].available(now) {
+				return &backends[i], true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// explain how the application would serve the request, it's resolved just like ServeHTTP does
+func (a *Application) explain(ctx *fasthttp.RequestCtx) explanation {
+	ps := acquireParams()
+	defer releaseParams(ps)
+	var buf []byte
+	res := a.resolve(ctx, ps, &buf)
+
+	e := explanation{
+		Allow: methodNames(res.allow), TSR: res.tsr, FixedPath: string(res.fixed), Redirect: string(res.redirect),
+		Ratio: res.ratio, BreakerOpen: res.ratio > openRatio, Status: res.status,
+	}
+	if res.n != nil && res.n.route != nil {
+		e.Route = res.n.route.path
+	}
+	for _, p := range *ps {
+		e.Params = append(e.Params, paramDump{string(p.key), string(p.value)})
+	}
+	if res.group != nil {
+		e.Group = res.group.name
+	}
+	if res.status != 0 {
+		return e
+	}
+
+	backend, ok := availableBackend(res.balancer)
+	if !ok {
+		e.Status = fasthttp.StatusForbidden
+		return e
+	}
+	e.Backend = backend.URL
+
+	return e
+}
+
+// explain how the request to host would be served, the name of app is filled in
+func (b *Breaker) explain(ctx *fasthttp.RequestCtx) explanation {
+	app := b.app(ctx.Host())
+	if app == nil {
+		return explanation{Status: fasthttp.StatusNotFound}
+	}
+
+	e := app.explain(ctx)
+	b.lock.Lock()
+	for name, a := range b.apps {
+		if a == app {
+			e.App = name
+		}
+	}
+	b.lock.Unlock()
+
+	return e
+}
+
+// treeHandler dump radix tree of the app
+func treeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	app, exist := breaker.get(r.URL.Query().Get("name"))
+	if !exist {
+		writeError(w, http.StatusNotFound, errAPPNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(app.Tree())
+}
+
+// explainHandler explain how a request would be served, given its host, method and path, e.g.
+// /app/explain?host=www.example.com&method=GET&path=/user/jhon
+func explainHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetHost(query.Get("host"))
+	ctx.Request.SetRequestURI(query.Get("path"))
+	if method := query.Get("method"); method != "" {
+		ctx.Request.Header.SetMethod(method)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(breaker.explain(ctx))
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestTreeDump(t *testing.T) {
+	a := NewApp(NewRR(), true)
+	a.AddRoute("/user/:id<int>", "GET", "DELETE")
+	a.AddRoute("/static/*path", "GET")
+
+	d := a.Tree()
+	if d.Tree.Path != "/" || d.Tree.Ring || len(d.Tree.Children) != 2 || d.DefaultRoute != nil {
+		t.Fatalf("bad dump of root: %+v", d)
+	}
+
+	user := d.Tree.Children[0].Children[0]
+	if user.Type != "param" || user.Constraint != "int" || user.Route != "/user/:id<int>" || !user.Ring {
+		t.Errorf("bad dump of param: %+v", user)
+	}
+	if len(user.Methods) != 2 || user.Methods[0] != "GET" || user.Methods[1] != "DELETE" {
+		t.Errorf("bad methods of param: %v", user.Methods)
+	}
+	static := d.Tree.Children[1].Children[0]
+	if static.Type != "catchAll" || static.Path != "*path" || static.Route != "/static/*path" {
+		t.Errorf("bad dump of catchAll: %+v", static)
+	}
+
+	a.SetDefaultRoute(true)
+	if d = a.Tree(); d.DefaultRoute == nil || d.DefaultRoute.Route != defaultRoutePath || !d.DefaultRoute.Ring {
+		t.Errorf("bad dump of default route: %+v", d.DefaultRoute)
+	}
+}
+
+func TestApplicationExplain(t *testing.T) {
+	a := NewApp(NewRR(NewBackend("127.0.0.1:8080", 1)), true)
+	a.AddRoute("/user/:name/card/*path", "GET")
+	a.AddRoute("/order/", "POST")
+	a.AddRoute("/broken", "GET")
+	broken, _, _ := a.tree().byPath([]byte("/broken"))
+	for i := 0; i < 10; i++ {
+		broken.incr(fasthttp.StatusBadGateway)
+	}
+
+	explain := func(method, path string) explanation {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(method)
+		ctx.Request.SetRequestURI(path)
+		return a.explain(ctx)
+	}
+
+	e := explain("GET", "/user/jhon/card/a/b")
+	if e.Status != 0 || e.Route != "/user/:name/card/*path" || e.Backend != "127.0.0.1:8080" || e.BreakerOpen {
+		t.Errorf("should be proxied but got: %+v", e)
+	}
+	if len(e.Params) != 2 || e.Params[0] != (paramDump{"name", "jhon"}) || e.Params[1] != (paramDump{"path", "a/b"}) {
+		t.Errorf("bad params: %+v", e.Params)
+	}
+	if len(e.Allow) != 1 || e.Allow[0] != "GET" {
+		t.Errorf("bad allowed methods: %v", e.Allow)
+	}
+	if e = explain("DELETE", "/user/jhon/card/a"); len(e.Allow) != 1 || e.Allow[0] != "GET" {
+		t.Errorf("allowed methods should be explained for 405, but got: %+v", e)
+	}
+
+	e = explain("POST", "/order")
+	if !e.TSR || e.Status != fasthttp.StatusTemporaryRedirect || e.Redirect != "/order/" {
+		t.Errorf("should be redirected but got: %+v", e)
+	}
+
+	expects := []struct {
+		method, path string
+		status       int
+	}{
+		{"GET", "/what", fasthttp.StatusNotFound},
+		{"DELETE", "/user/jhon/card/a", fasthttp.StatusMethodNotAllowed},
+		{"BREW", "/user/jhon/card/a", fasthttp.StatusNotImplemented},
+		{"GET", "/broken", fasthttp.StatusTooManyRequests},
+	}
+	for _, e := range expects {
+		if got := explain(e.method, e.path); got.Status != e.status {
+			t.Errorf("%s %s should be answered with %d but got: %+v", e.method, e.path, e.status, got)
+		}
+	}
+	if e = explain("GET", "/broken"); !e.BreakerOpen || e.Ratio <= openRatio {
+		t.Errorf("breaker should be open but got: %+v", e)
+	}
+
+	// the balancer is untouched
+	rr := NewRR(NewBackend("127.0.0.1:8080", 1), NewBackend("127.0.0.1:8081", 1))
+	a.balancer = rr
+	for i := 0; i < 3; i++ {
+		if e = explain("GET", "/user/jhon/card/a"); e.Backend != "127.0.0.1:8080" {
+			t.Errorf("should explain the first available backend, but got: %+v", e)
+		}
+	}
+	if rr.index != 0 {
+		t.Errorf("state of balancer should not be changed, but index is: %d", rr.index)
+	}
+
+	a.fixPath = fixPathMatch
+	if e = explain("GET", "/USER/jhon/card/a"); e.FixedPath != "/user/jhon/card/a" || e.Route != "/user/:name/card/*path" {
+		t.Errorf("path should be fixed but got: %+v", e)
+	}
+}
+
+func TestExplainHandler(t *testing.T) {
+	app := NewApp(NewRR(NewBackend("127.0.0.1:8080", 1)), true)
+	app.AddRoute("/user/:name", "GET")
+	app.hosts = []string{"*.explain.example.com"}
+	breaker.setApp("explain.example.com", app)
+
+	fakeServer := httptest.NewServer(http.HandlerFunc(explainHandler))
+	defer fakeServer.Close()
+
+	query := url.Values{"host": {"api.explain.example.com:23456"}, "path": {"/user/jhon"}}
+	resp, err := http.Get(fakeServer.URL + "/app/explain?" + query.Encode())
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("should return 200, but got: %v, %v", resp, err)
+	}
+	defer resp.Body.Close()
+
+	var e explanation
+	if err := json.NewDecoder(resp.Body).Decode(&e); err != nil {
+		t.Fatalf("failed to decode explanation: %s", err)
+	}
+	if e.App != "explain.example.com" || e.Route != "/user/:name" || e.Backend != "127.0.0.1:8080" {
+		t.Errorf("bad explanation: %+v", e)
+	}
+
+	treeServer := httptest.NewServer(http.HandlerFunc(treeHandler))
+	defer treeServer.Close()
+	resp, err = http.Get(treeServer.URL + "/app/tree?name=explain.example.com")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("should return 200, but got: %v, %v", resp, err)
+	}
+	resp, err = http.Get(treeServer.URL + "/app/tree?name=what")
+	if err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("should return 404, but got: %v, %v", resp, err)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+)
+
+/*
+fixing paths, a request which matches no route may be cleaned and matched case-insensitively,
+e.g. `/User//Profile/../jhon` is fixed to `/user/jhon` by route `/user/:name`. the request is
+then redirected to the fixed path, or served by the route silently, its path is unchanged then.
+static parts take the case of the route, and wildcards keep the case of the request.
+*/
+
+const (
+	fixPathRedirect = "redirect" // redirect to the fixed path
+	fixPathMatch    = "match"    // serve by the route of the fixed path silently
+)
+
+var errBadFixPath = errors.New("fix path should be redirect or match")
+
+// appendCleanPath append the canonical form of p to buf, like path.Clean, but it's always
+// rooted and the trailing slash is kept, e.g. `user//profile/../jhon/` is `/user/jhon/`
+func appendCleanPath(buf, p []byte) []byte {
+	start := len(buf)
+	buf = append(buf, '/')
+
+	// buf[start:] always ends with `/` in the loop
+	for i := 0; i < len(p); {
+		if p[i] == '/' {
+			i++
+			continue
+		}
+
+		end := i
+		for end < len(p) && p[end] != '/' {
+			end++
+		}
+		segment := p[i:end]
+		i = end
+
+		switch {
+		case len(segment) == 1 && segment[0] == '.':
+		case len(segment) == 2 && segment[0] == '.' && segment[1] == '.':
+			// drop the last segment, if any
+			if len(buf) > start+1 {
+				buf = buf[:len(buf)-1]
+				for buf[len(buf)-1] != '/' {
+					buf = buf[:len(buf)-1]
+				}
+			}
+		default:
+			buf = append(append(buf, segment...), '/')
+		}
+	}
+
+	if len(buf) > start+1 && (len(p) == 0 || p[len(p)-1] != '/') {
+		buf = buf[:len(buf)-1]
+	}
+	return buf
+}
+
+func lower(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+// equalFold is bytes.EqualFold of ASCII letters only
+func equalFold(a, b []byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if lower(a[i]) != lower(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchFold is match without capturing, but static parts are compared case-insensitively, and
+// the path with the case of route is appended to buf. buf is truncated back if nothing matches
+func (n *node) matchFold(path []byte, buf []byte) ([]byte, *node) {
+	start := len(buf)
+	if len(path) < len(n.path) || !equalFold(path[:len(n.path)], n.path) {
+		return buf, nil
+	}
+
+	buf = append(buf, n.path...)
+	path = path[len(n.path):]
+	if len(path) == 0 {
+		if n.isLeaf {
+			return buf, n
+		}
+		return buf[:start], nil
+	}
+
+	// e.g. both `/User` and `/user` may be static children
+	mark := len(buf)
+	c := lower(path[0])
+	for i := 0; i < len(n.indices); i++ {
+		if lower(n.indices[i]) != c {
+			continue
+		}
+
+		var nd *node
+		if buf, nd = n.children[i].matchFold(path, buf); nd != nil {
+			return buf, nd
+		}
+	}
+
+	for _, child := range n.wildChildren() {
+		switch child.nType {
+		case param:
+			end := 0
+			for end < len(path) && path[end] != '/' {
+				end++
+			}
+			if end == 0 || (child.constraint != nil && !child.constraint.match(path[:end])) {
+				continue
+			}
+
+			buf = append(buf, path[:end]...)
+			if end == len(path) && child.isLeaf {
+				return buf, child
+			}
+			if end < len(path) && len(child.children) > 0 {
+				var nd *node
+				if buf, nd = child.children[0].matchFold(path[end:], buf); nd != nil {
+					return buf, nd
+				}
+			}
+			buf = buf[:mark]
+		case catchAll:
+			return append(buf, path...), child
+		}
+	}
+
+	return buf[:start], nil
+}
+
+// fixPath clean path and match it case-insensitively, the fixed path is appended to buf. the
+// trailing slash is added or removed if it's needed and fixTrailingSlash is true
+func (n *node) fixPath(path []byte, fixTrailingSlash bool, buf []byte) ([]byte, bool) {
+	bufp := acquireBuf()
+	cleaned := appendCleanPath(*bufp, path)
+	defer func() {
+		*bufp = cleaned
+		releaseBuf(bufp)
+	}()
+
+	buf, nd := n.matchFold(cleaned, buf)
+	if nd != nil || !fixTrailingSlash {
+		return buf, nd != nil
+	}
+
+	if len(cleaned) > 1 && cleaned[len(cleaned)-1] == '/' {
+		buf, nd = n.matchFold(cleaned[:len(cleaned)-1], buf)
+	} else {
+		cleaned = append(cleaned, '/')
+		buf, nd = n.matchFold(cleaned, buf)
+	}
+	return buf, nd != nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestAppendCleanPath(t *testing.T) {
+	expects := []struct {
+		path, cleaned string
+	}{
+		{"", "/"},
+		{"/", "/"},
+		{"//", "/"},
+		{"user", "/user"},
+		{"/user/", "/user/"},
+		{"/user//profile", "/user/profile"},
+		{"/user/./profile/", "/user/profile/"},
+		{"/user/profile/..", "/user"},
+		{"/User//Profile/../jhon", "/User/jhon"},
+		{"/../..", "/"},
+		{"/../user/..", "/"},
+		{"/user/..jhon/.", "/user/..jhon"},
+	}
+	for _, e := range expects {
+		if cleaned := string(appendCleanPath(nil, []byte(e.path))); cleaned != e.cleaned {
+			t.Errorf("%s should be cleaned to %s, but got: %s", e.path, e.cleaned, cleaned)
+		}
+	}
+
+	if buf := string(appendCleanPath([]byte("x"), []byte("a/../b"))); buf != "x/b" {
+		t.Errorf("cleaned path should be appended, but got: %s", buf)
+	}
+}
+
+func TestFixPath(t *testing.T) {
+	n := &node{}
+	n.addRoute([]byte("/user/:name"), GET)
+	n.addRoute([]byte("/user/new"), GET)
+	n.addRoute([]byte("/User/Admin/"), GET)
+	n.addRoute([]byte("/order/:id<int>/items"), GET)
+	n.addRoute([]byte("/static/*path"), GET)
+
+	expects := []struct {
+		path  string
+		fixed string
+		found bool
+	}{
+		{"/USER/NEW", "/user/new", true},
+		{"/User//Profile/../Jhon", "/user/Jhon", true},
+		{"/user/admin/", "/User/Admin/", true},
+		{"/user/admin", "/user/admin", true}, // the param matches, no slash is needed
+		{"/ORDER/42/ITEMS", "/order/42/items", true},
+		{"/order/jhon/items", "", false},
+		{"/Static/./A/b.css", "/static/A/b.css", true},
+		{"/user/jhon/card", "", false},
+		{"/order/42/items/", "/order/42/items", true},
+	}
+	for _, e := range expects {
+		fixed, found := n.fixPath([]byte(e.path), true, nil)
+		if found != e.found || string(fixed) != e.fixed {
+			t.Errorf("%s should be fixed to %s(%t), but got: %s(%t)", e.path, e.fixed, e.found, fixed, found)
+		}
+	}
+
+	if _, found := n.fixPath([]byte("/order/42/items/"), false, nil); found {
+		t.Errorf("trailing slash should not be fixed")
+	}
+}
+
+func TestApplicationFixPath(t *testing.T) {
+	backend, stop := inmemoryBackend(t, func(ctx *fasthttp.RequestCtx) {
+		ctx.Write(ctx.Path())
+	})
+	defer stop()
+
+	a := NewApp(NewRR(backend), true)
+	a.AddRoute("/user/:name", "GET", "POST")
+
+	serve := func(method, uri string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(method)
+		ctx.Request.SetRequestURI("http://example.com" + uri)
+		a.ServeHTTP(ctx)
+		return ctx
+	}
+
+	// disabled by default
+	if code := serve("GET", "/USER/jhon").Response.StatusCode(); code != fasthttp.StatusNotFound {
+		t.Errorf("should return 404 but got: %d", code)
+	}
+
+	a.fixPath = fixPathRedirect
+	ctx := serve("GET", "/User//jhon?v=1")
+	if code := ctx.Response.StatusCode(); code != fasthttp.StatusMovedPermanently {
+		t.Errorf("should return 301 but got: %d", code)
+	}
+	if location := string(ctx.Response.Header.Peek("Location")); location != "http://example.com/user/jhon?v=1" {
+		t.Errorf("should redirect to the fixed path but got: %s", location)
+	}
+	if code := serve("POST", "/USER/jhon").Response.StatusCode(); code != fasthttp.StatusTemporaryRedirect {
+		t.Errorf("should return 307 but got: %d", code)
+	}
+
+	a.fixPath = fixPathMatch
+	ctx = serve("GET", "/USER/jhon")
+	if code := ctx.Response.StatusCode(); code != fasthttp.StatusOK {
+		t.Errorf("should return 200 but got: %d", code)
+	}
+	if body := string(ctx.Response.Body()); body != "/USER/jhon" {
+		t.Errorf("path should be unchanged but got: %s", body)
+	}
+	n, _, _ := a.tree().byPath([]byte("/user/jhon"))
+	if requests, _, _, _, _ := n.query(); requests != 1 {
+		t.Errorf("route should have 1 request, but got: %d", requests)
+	}
+}
+
+func TestCheckFixPathConfig(t *testing.T) {
+	config := &appConfig{Name: "www.example.com", FixPath: fixPathMatch}
+	if err := checkAppConfig(config); err != nil {
+		t.Errorf("should not return error but got: %s", err)
+	}
+
+	config.FixPath = "what"
+	if err := checkAppConfig(config); err != errBadFixPath {
+		t.Errorf("should return %s but got: %v", errBadFixPath, err)
+	}
+}
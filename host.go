@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+)
+
+/*
+host matching, requests are proxied by the app whose hosts match the host of request. the port
+is ignored, and hosts are case-insensitive. a host is either an exact name, e.g. `example.com`,
+or a wildcard of subdomains, e.g. `*.example.com` which matches `a.example.com` and
+`a.b.example.com` but not `example.com`. exact names take precedence over wildcards, and longer
+wildcards over shorter ones. requests whose host matches no app are proxied by the default app.
+*/
+
+// maxHostLen is the max length of a domain name
+const maxHostLen = 255
+
+var (
+	errBadHost          = errors.New("host should be a name without port, or a wildcard like *.example.com")
+	errHostExists       = errors.New("host is used by another app")
+	errDefaultAppExists = errors.New("there is another default app")
+)
+
+// hostTable is replaced as a whole when an app is set, so it can be read without lock
+type hostTable struct {
+	exact    map[string]*Application
+	wildcard map[string]*Application // e.g. `.example.com` of `*.example.com`
+	fallback *Application            // nil if there is no default app
+}
+
+// hostKey return host without port in lower case, it's appended to buf
+func hostKey(buf []byte, host []byte) []byte {
+	// e.g. `[::1]:80`
+	if i := bytes.LastIndexByte(host, ':'); i >= 0 && bytes.IndexByte(host[i:], ']') < 0 {
+		host = host[:i]
+	}
+	for _, c := range host {
+		buf = append(buf, lower(c))
+	}
+
+	return buf
+}
+
+// checkHosts check hosts of an app and make them lower case
+func checkHosts(hosts []string) error {
+	for i, host := range hosts {
+		name := strings.TrimPrefix(host, "*")
+		if name == "" || len(name) > maxHostLen || strings.ContainsAny(name, ":/*") ||
+			(len(name) < len(host) && (name[0] != '.' || len(name) == 1)) {
+			return errBadHost
+		}
+		hosts[i] = strings.ToLower(host)
+	}
+
+	return nil
+}
+
+// hostsOf return hosts of app whose name is name, including the name itself
+func hostsOf(name string, hosts []string) []string {
+	return append([]string{string(hostKey(nil, []byte(name)))}, hosts...)
+}
+
+// checkAppHosts return an error if hosts of app whose name is name are used by other apps, or
+// there is another default app. the app is replaced if it exists, so its hosts are not checked.
+// it should be called with b.lock held
+func (b *Breaker) checkAppHosts(name string, hosts []string, defaultApp bool) error {
+	used := map[string]bool{}
+	for _, host := range hostsOf(name, hosts) {
+		used[host] = true
+	}
+	for otherName, other := range b.apps {
+		if otherName == name {
+			continue
+		}
+		if defaultApp && other.defaultApp {
+			return errDefaultAppExists
+		}
+		for _, host := range hostsOf(otherName, other.hosts) {
+			if used[host] {
+				return errHostExists
+			}
+		}
+	}
+
+	return nil
+}
+
+// setApp set app whose name is name, it replaces the old one whose name is the same, which is
+// returned. nothing is changed if hosts of app are used by other apps, see checkAppHosts
+func (b *Breaker) setApp(name string, app *Application) (*Application, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if err := b.checkAppHosts(name, app.hosts, app.defaultApp); err != nil {
+		return nil, err
+	}
+	return b.putApp(name, app), nil
+}
+
+// buildApp build an app from a checked configuration and set it like setApp, hosts are checked
+// before it's built, so a rejected configuration neither registers methods nor starts a canary
+func (b *Breaker) buildApp(name string, config *appConfig) (*Application, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if err := b.checkAppHosts(name, config.Hosts, config.DefaultApp); err != nil {
+		return nil, err
+	}
+	app, err := getAPP(config)
+	if err != nil {
+		return nil, err
+	}
+
+	old := b.putApp(name, app)
+	if app.canary != nil {
+		app.canary.run()
+	}
+	return old, nil
+}
+
+// putApp put app in apps and rebuild the table of hosts, the old one is returned. it should be
+// called with b.lock held
+func (b *Breaker) putApp(name string, app *Application) *Application {
+	old := b.apps[name]
+	b.apps[name] = app
+
+	t := &hostTable{exact: map[string]*Application{}, wildcard: map[string]*Application{}}
+	for name, app := range b.apps {
+		for _, host := range hostsOf(name, app.hosts) {
+			if strings.HasPrefix(host, "*") {
+				t.wildcard[host[1:]] = app
+			} else {
+				t.exact[host] = app
+			}
+		}
+		if app.defaultApp {
+			t.fallback = app
+		}
+	}
+	b.hosts.Store(t)
+
+	return old
+}
+
+// get return the app whose name is name
+func (b *Breaker) get(name string) (*Application, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	app, exist := b.apps[name]
+	return app, exist
+}
+
+// app return the app which host matches, nil if there is none
+func (b *Breaker) app(host []byte) *Application {
+	t, _ := b.hosts.Load().(*hostTable)
+	if t == nil {
+		return nil
+	}
+
+	var buf [maxHostLen]byte
+	if len(host) > len(buf) {
+		return t.fallback
+	}
+	key := hostKey(buf[:0], host)
+
+	if app, exist := t.exact[string(key)]; exist {
+		return app
+	}
+	// the longest wildcard first, e.g. `.b.example.com` before `.example.com` for `a.b.example.com`
+	for i := 0; i < len(key); i++ {
+		if key[i] != '.' {
+			continue
+		}
+		if app, exist := t.wildcard[string(key[i:])]; exist {
+			return app
+		}
+	}
+
+	return t.fallback
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestHostKey(t *testing.T) {
+	expects := []struct {
+		host, key string
+	}{
+		{"example.com", "example.com"},
+		{"Example.COM:23456", "example.com"},
+		{"127.0.0.1:80", "127.0.0.1"},
+		{"[::1]:80", "[::1]"},
+		{"[::1]", "[::1]"},
+		{"", ""},
+	}
+	for _, e := range expects {
+		if key := string(hostKey(nil, []byte(e.host))); key != e.key {
+			t.Errorf("key of %s should be %s, but got: %s", e.host, e.key, key)
+		}
+	}
+}
+
+func TestCheckHosts(t *testing.T) {
+	hosts := []string{"Example.com", "*.Example.com"}
+	if err := checkHosts(hosts); err != nil {
+		t.Errorf("should not return error but got: %s", err)
+	}
+	if hosts[0] != "example.com" || hosts[1] != "*.example.com" {
+		t.Errorf("hosts should be lower case, but got: %v", hosts)
+	}
+
+	for _, host := range []string{"", "*", "*.", "*example.com", "example.com:80", "a.*.example.com", "example.com/"} {
+		if err := checkHosts([]string{host}); err != errBadHost {
+			t.Errorf("%s should be bad, but got: %v", host, err)
+		}
+	}
+}
+
+func TestBreakerHosts(t *testing.T) {
+	b := NewBreaker()
+	if app := b.app([]byte("example.com")); app != nil {
+		t.Errorf("no app should be found but got: %+v", app)
+	}
+
+	www, api, sub, fallback := NewApp(NewRR(), true), NewApp(NewRR(), true), NewApp(NewRR(), true), NewApp(NewRR(), true)
+	www.hosts = []string{"example.com"}
+	api.hosts = []string{"*.example.com"}
+	sub.hosts = []string{"*.b.example.com"}
+	b.setApp("www.example.com", www)
+	b.setApp("api.example.com", api)
+	b.setApp("sub", sub)
+
+	expects := []struct {
+		host string
+		app  *Application
+	}{
+		{"www.example.com", www},
+		{"WWW.example.com:23456", www},
+		{"example.com", www},
+		{"api.example.com", api},
+		{"a.example.com", api},
+		{"a.b.example.com", sub},
+		{"b.example.com", api},
+		{"example.org", nil},
+		{"", nil},
+	}
+	check := func() {
+		for _, e := range expects {
+			if app := b.app([]byte(e.host)); app != e.app {
+				t.Errorf("%s should be served by %p, but got: %p", e.host, e.app, app)
+			}
+		}
+	}
+	check()
+
+	fallback.defaultApp = true
+	b.setApp("fallback", fallback)
+	expects[len(expects)-2].app, expects[len(expects)-1].app = fallback, fallback
+	check()
+
+	// replaced as a whole
+	b.setApp("sub", NewApp(NewRR(), true))
+	if app := b.app([]byte("a.b.example.com")); app != api {
+		t.Errorf("a.b.example.com should be served by api now but got: %p", app)
+	}
+}
+
+func TestCheckAppHosts(t *testing.T) {
+	b := NewBreaker()
+	app := NewApp(NewRR(), true)
+	app.hosts = []string{"*.example.com"}
+	app.defaultApp = true
+	b.setApp("www.example.com", app)
+
+	expects := []struct {
+		name       string
+		hosts      []string
+		defaultApp bool
+		err        error
+	}{
+		{"www.example.com", []string{"*.example.com"}, true, nil}, // replace itself
+		{"api.example.com", []string{"api.example.org"}, false, nil},
+		{"api.example.com", []string{"*.example.com"}, false, errHostExists},
+		{"WWW.example.com:80", nil, false, errHostExists},
+		{"api.example.com", nil, true, errDefaultAppExists},
+	}
+	for _, e := range expects {
+		if err := b.checkAppHosts(e.name, e.hosts, e.defaultApp); err != e.err {
+			t.Errorf("%s should return %v but got: %v", e.name, e.err, err)
+		}
+	}
+}
+
+func TestSetAppHostExists(t *testing.T) {
+	b := NewBreaker()
+
+	// only one of apps which claim the same host at the same time is set
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			app := NewApp(NewRR(), true)
+			app.hosts = []string{"*.example.com"}
+			_, err := b.setApp("app"+strconv.Itoa(i), app)
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	set := 0
+	for err := range errs {
+		if err == nil {
+			set++
+		} else if err != errHostExists {
+			t.Errorf("should return %s but got: %s", errHostExists, err)
+		}
+	}
+	if set != 1 || len(b.apps) != 1 {
+		t.Errorf("only one app should be set, but got: %d, %d", set, len(b.apps))
+	}
+
+	// replace itself
+	for name := range b.apps {
+		old, _ := b.get(name)
+		app := NewApp(NewRR(), true)
+		app.hosts = []string{"*.example.com"}
+		if replaced, err := b.setApp(name, app); err != nil || replaced != old {
+			t.Errorf("old app should be replaced, but got: %p, %v", replaced, err)
+		}
+	}
+}
+
+func BenchmarkBreakerApp(b *testing.B) {
+	breaker := NewBreaker()
+	app := NewApp(NewRR(), true)
+	app.hosts = []string{"*.example.com"}
+	breaker.setApp("www.example.com", app)
+	host := []byte("Api.Example.com:23456")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		breaker.app(host)
+	}
+}
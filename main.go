@@ -17,6 +17,7 @@ var (
 	proxyAddr  = flag.String("proxyAddr", ":23456", "proxy server listen at")
 	configAddr = flag.String("configAddr", ":12345", "config server listen at")
 	configPath = flag.String("configPath", "/tmp/guard.json", "configuration sync path")
+	localZone  = flag.String("zone", "", "zone this instance is in, for zone-aware load balancing")
 
 	breaker = NewBreaker()
 )
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync/atomic"
+)
+
+/*
+dynamic backend membership, backends of a running application can be added, removed
+and reweighted without rebuilding the application, so the radix tree stats, the state of
+balancer and the connection pools of unchanged backends are kept.
+*/
+
+var (
+	errAPPNotFound     = errors.New("app not found")
+	errBackendEmpty    = errors.New("backend is required")
+	errBackendExists   = errors.New("backend already exists")
+	errBackendNotFound = errors.New("backend not found")
+	errBadWeight       = errors.New("weight should not be negative")
+)
+
+type backendConfig struct {
+	Name    string `json:"name"`    // name of the app
+	Backend string `json:"backend"` // e.g. "192.168.1.1:80"
+	Weight  int    `json:"weight"`
+	Backup  bool   `json:"backup"`
+	Zone    string `json:"zone,omitempty"`
+}
+
+// backendStatus is what admin API shows for a backend
+type backendStatus struct {
+	URL             string  `json:"url"`
+	Weight          int     `json:"weight"`
+	Backup          bool    `json:"backup"`
+	Zone            string  `json:"zone,omitempty"`
+	EffectiveWeight float64 `json:"effective_weight"` // less than weight in slow start window
+	SlowStart       bool    `json:"slow_start"`
+	Requests        uint32  `json:"requests"` // requests in the current window
+	Failures        uint32  `json:"failures"` // failures in the current window
+	Latency         float64 `json:"latency"`  // average latency in the current window, in milliseconds
+	Ejected         bool    `json:"ejected"`
+	Draining        bool    `json:"draining"`
+	Inflight        int64   `json:"inflight"` // it's safe to stop a draining backend if it's 0
+}
+
+func (b *Backend) status(now int64) backendStatus {
+	factor := b.warmFactor(now)
+	s := backendStatus{
+		URL:             b.URL,
+		Weight:          b.Weight,
+		Backup:          b.Backup,
+		Zone:            b.Zone,
+		EffectiveWeight: float64(b.Weight) * factor,
+		SlowStart:       factor < 1,
+	}
+
+	if b.stats != nil {
+		requests, failures, latency := b.stats.query()
+		s.Requests, s.Failures, s.Latency = requests, failures, latency.Seconds()*1000
+		s.Ejected = b.stats.isEjected(now)
+		s.Draining = atomic.LoadUint32(&b.stats.draining) != 0
+		s.Inflight = atomic.LoadInt64(&b.stats.inflight)
+	}
+
+	return s
+}
+
+// appStatus is what admin API shows for an application
+type appStatus struct {
+	Name       string `json:"name"`
+	Failovers  uint64 `json:"failovers"`   // requests proxied to backup backends
+	FailedOver bool   `json:"failed_over"` // true if none of primary backends is available now
+
+	// zone-aware balancer only, requests proxied to primary backends in the local zone, and
+	// in other zones
+	Zone              string  `json:"zone,omitempty"`
+	LocalRequests     uint64  `json:"local_requests,omitempty"`
+	CrossZoneRequests uint64  `json:"cross_zone_requests,omitempty"`
+	CrossZoneRatio    float64 `json:"cross_zone_ratio,omitempty"`
+}
+
+// Status return status of the application
+func (a *Application) Status() appStatus {
+	now := CoarseTimeNow().Unix()
+	backends := a.balancer.Backends()
+
+	// it's failed over if there are backup backends, but none of primary ones is available
+	hasBackup, hasPrimary := false, false
+	for i := range backends {
+		if backends[i].Backup {
+			hasBackup = true
+		} else if backends[i].available(now) {
+			hasPrimary = true
+		}
+	}
+
+	status := appStatus{Failovers: a.balancer.Failovers(), FailedOver: hasBackup && !hasPrimary}
+	if z, ok := a.balancer.(*Zoned); ok {
+		status.Zone = z.localZone
+		status.LocalRequests, status.CrossZoneRequests = z.Requests()
+		if total := status.LocalRequests + status.CrossZoneRequests; total > 0 {
+			status.CrossZoneRatio = float64(status.CrossZoneRequests) / float64(total)
+		}
+	}
+
+	return status
+}
+
+// BackendStatus return status of all backends of the application
+func (a *Application) BackendStatus() []backendStatus {
+	now := CoarseTimeNow().Unix()
+	backends := a.balancer.Backends()
+
+	status := make([]backendStatus, 0, len(backends))
+	for i := range backends {
+		status = append(status, backends[i].status(now))
+	}
+
+	return status
+}
+
+// watch let backends follow the outlier detection and slow start policy of the application
+func (a *Application) watch(backends ...Backend) {
+	for _, b := range backends {
+		if b.stats != nil {
+			b.stats.slowStart = a.slowStart
+		}
+	}
+	a.detector.watch(backends...)
+}
+
+// updateBackends replace backends of balancer and sync the configuration, it should be
+// called with a.lock held. backends found by discoverers are not written to configuration.
+func (a *Application) updateBackends(backends []Backend) {
+	a.balancer.Update(backends...)
+
+	if a.config == nil {
+		return
+	}
+
+	a.config.Backends, a.config.Weights, a.config.Zones = []string{}, []int{}, []string{}
+	a.config.BackupBackends, a.config.BackupWeights = nil, nil
+	hasZone := false
+	for _, b := range backends {
+		if b.source != "" {
+			continue
+		}
+
+		if b.Backup {
+			a.config.BackupBackends = append(a.config.BackupBackends, b.URL)
+			a.config.BackupWeights = append(a.config.BackupWeights, b.Weight)
+		} else {
+			a.config.Backends = append(a.config.Backends, b.URL)
+			a.config.Weights = append(a.config.Weights, b.Weight)
+			a.config.Zones = append(a.config.Zones, b.Zone)
+			hasZone = hasZone || b.Zone != ""
+		}
+	}
+	if !hasZone {
+		a.config.Zones = nil
+	}
+
+	config := *a.config
+	go func() { configSync <- config }()
+}
+
+// AddBackend add a backend to the running application
+func (a *Application) AddBackend(url string, weight int, backup bool, zone string) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	current := a.balancer.Backends()
+	for _, b := range current {
+		if b.URL == url {
+			return errBackendExists
+		}
+	}
+
+	// the new backend should warm up before it receives its full share of traffic
+	backend := NewBackend(url, weight)
+	backend.stats.addedAt = CoarseTimeNow().Unix()
+	backend.Backup = backup
+	backend.Zone = zone
+	a.watch(backend)
+
+	// never append to the slice in use, balancer may be reading it
+	backends := make([]Backend, 0, len(current)+1)
+	backends = append(backends, current...)
+	backends = append(backends, backend)
+	a.updateBackends(backends)
+
+	return nil
+}
+
+// RemoveBackend remove backends with the given url from the running application
+func (a *Application) RemoveBackend(url string) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	current := a.balancer.Backends()
+	backends := make([]Backend, 0, len(current))
+	removed := []Backend{}
+	for _, b := range current {
+		if b.URL == url {
+			removed = append(removed, b)
+		} else {
+			backends = append(backends, b)
+		}
+	}
+
+	if len(removed) == 0 {
+		return errBackendNotFound
+	}
+
+	a.detector.unwatch(removed...)
+	a.updateBackends(backends)
+
+	return nil
+}
+
+// SetWeight change weight of backends with the given url, connections to them are kept
+func (a *Application) SetWeight(url string, weight int) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	current := a.balancer.Backends()
+	backends := make([]Backend, len(current))
+	found := false
+	for i, b := range current {
+		if b.URL == url {
+			b.Weight = weight
+			found = true
+		}
+		backends[i] = b
+	}
+
+	if !found {
+		return errBackendNotFound
+	}
+
+	a.updateBackends(backends)
+
+	return nil
+}
+
+// Drain mark backends with the given url as draining or not, a draining backend will not
+// be selected by balancer, but requests which are being proxied to it are not affected.
+// it return status of these backends, so the caller knows how many requests are in flight.
+func (a *Application) Drain(url string, draining bool) ([]backendStatus, error) {
+	var flag uint32
+	if draining {
+		flag = 1
+	}
+
+	now := CoarseTimeNow().Unix()
+	backends := a.balancer.Backends()
+	status := []backendStatus{}
+	for i := range backends {
+		b := &backends[i]
+		if b.URL != url || b.stats == nil {
+			continue
+		}
+
+		atomic.StoreUint32(&b.stats.draining, flag)
+		status = append(status, b.status(now))
+	}
+
+	if len(status) == 0 {
+		return nil, errBackendNotFound
+	}
+
+	return status, nil
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	w.WriteHeader(code)
+	w.Write([]byte("bad request: " + err.Error()))
+}
+
+func backendHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		app, exist := breaker.get(r.URL.Query().Get("name"))
+		if !exist {
+			writeError(w, http.StatusNotFound, errAPPNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(app.BackendStatus())
+		return
+	}
+
+	defer r.Body.Close()
+	var config backendConfig
+
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if config.Backend == "" {
+		writeError(w, http.StatusBadRequest, errBackendEmpty)
+		return
+	}
+	if config.Weight < 0 {
+		writeError(w, http.StatusBadRequest, errBadWeight)
+		return
+	}
+
+	app, exist := breaker.get(config.Name)
+	if !exist {
+		writeError(w, http.StatusNotFound, errAPPNotFound)
+		return
+	}
+
+	var err error
+	switch r.Method {
+	case "POST":
+		err = app.AddBackend(config.Backend, config.Weight, config.Backup, config.Zone)
+	case "PUT":
+		err = app.SetWeight(config.Backend, config.Weight)
+	case "DELETE":
+		err = app.RemoveBackend(config.Backend)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch err {
+	case nil:
+		w.Write([]byte("success!"))
+	case errBackendExists:
+		writeError(w, http.StatusConflict, err)
+	case errBackendNotFound:
+		writeError(w, http.StatusNotFound, err)
+	default:
+		writeError(w, http.StatusBadRequest, err)
+	}
+}
+
+// drainHandler start draining a backend by POST, and stop it by DELETE
+func drainHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" && r.Method != "DELETE" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	defer r.Body.Close()
+	var config backendConfig
+
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	app, exist := breaker.get(config.Name)
+	if !exist {
+		writeError(w, http.StatusNotFound, errAPPNotFound)
+		return
+	}
+
+	status, err := app.Drain(config.Backend, r.Method == "POST")
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+func appStatusHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	app, exist := breaker.get(name)
+	if !exist {
+		writeError(w, http.StatusNotFound, errAPPNotFound)
+		return
+	}
+
+	status := app.Status()
+	status.Name = name
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMembership(t *testing.T) {
+	b1 := NewBackend("192.168.1.1:80", 5)
+	b2 := NewBackend("192.168.1.2:80", 1)
+
+	for _, balancer := range []Balancer{NewWRR(b1, b2), NewRR(b1, b2), NewRdm(b1, b2)} {
+		a := NewApp(balancer, true)
+
+		if err := a.AddBackend("192.168.1.3:80", 1, false, ""); err != nil {
+			t.Errorf("should not return error but got: %s", err)
+		}
+		if err := a.AddBackend("192.168.1.3:80", 1, false, ""); err != errBackendExists {
+			t.Errorf("should return %s but got: %s", errBackendExists, err)
+		}
+		if n := len(balancer.Backends()); n != 3 {
+			t.Errorf("%T should have 3 backends, but got: %d", balancer, n)
+		}
+
+		if err := a.SetWeight("192.168.1.1:80", 2); err != nil {
+			t.Errorf("should not return error but got: %s", err)
+		}
+		if err := a.SetWeight("192.168.1.4:80", 2); err != errBackendNotFound {
+			t.Errorf("should return %s but got: %s", errBackendNotFound, err)
+		}
+		backends := balancer.Backends()
+		if backends[0].Weight != 2 || backends[0].client != b1.client || backends[0].stats != b1.stats {
+			t.Errorf("%T should keep the client and stats of reweighted backend, but got: %+v", balancer, backends[0])
+		}
+
+		if err := a.RemoveBackend("192.168.1.2:80"); err != nil {
+			t.Errorf("should not return error but got: %s", err)
+		}
+		if err := a.RemoveBackend("192.168.1.2:80"); err != errBackendNotFound {
+			t.Errorf("should return %s but got: %s", errBackendNotFound, err)
+		}
+		status := a.BackendStatus()
+		if len(status) != 2 || status[0].URL != "192.168.1.1:80" || status[1].URL != "192.168.1.3:80" {
+			t.Errorf("%T has wrong backends: %+v", balancer, status)
+		}
+
+		for i := 0; i < 10; i++ {
+			if b, found := balancer.Select(); !found || b.URL == "192.168.1.2:80" {
+				t.Errorf("%T should not select removed backend, but got: %+v, %t", balancer, b, found)
+			}
+		}
+	}
+}
+
+func TestWRRUpdateKeepWeights(t *testing.T) {
+	b1 := NewBackend("192.168.1.1:80", 5)
+	b2 := NewBackend("192.168.1.2:80", 1)
+	b3 := NewBackend("192.168.1.3:80", 1)
+
+	wrr := NewWRR(b1, b2)
+	wrr.Select()
+	wrr.Update(b1, b3, b2)
+
+	if wrr.weights[0] != -1 || wrr.weights[1] != 0 || wrr.weights[2] != 1 {
+		t.Errorf("current weights of kept backends should not change, but got: %+v", wrr.weights)
+	}
+}
+
+func TestBackendHandler(t *testing.T) {
+	fakeServer := httptest.NewServer(http.HandlerFunc(backendHandler))
+	defer fakeServer.Close()
+	url := fakeServer.URL + "/app/backend"
+
+	appName := "membership.example.com"
+	breaker.apps[appName] = NewApp(NewRR(NewBackend("192.168.1.1:80", 1)), true)
+	defer delete(breaker.apps, appName)
+
+	do := func(method string, body string) int {
+		req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to request backend handler: %s", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	expects := []struct {
+		method string
+		body   string
+		code   int
+	}{
+		{"POST", `{"name":"membership.example.com","backend":"192.168.1.2:80","weight":1}`, http.StatusOK},
+		{"POST", `{"name":"membership.example.com","backend":"192.168.1.2:80","weight":1}`, http.StatusConflict},
+		{"PUT", `{"name":"membership.example.com","backend":"192.168.1.2:80","weight":3}`, http.StatusOK},
+		{"DELETE", `{"name":"membership.example.com","backend":"192.168.1.1:80"}`, http.StatusOK},
+		{"DELETE", `{"name":"membership.example.com","backend":"192.168.1.1:80"}`, http.StatusNotFound},
+		{"POST", `{"name":"what.example.com","backend":"192.168.1.2:80","weight":1}`, http.StatusNotFound},
+		{"POST", `{"name":"membership.example.com","weight":1}`, http.StatusBadRequest},
+		{"POST", `{"name":"membership.example.com","backend":"192.168.1.2:80","weight":-1}`, http.StatusBadRequest},
+		{"POST", `what`, http.StatusBadRequest},
+		{"PATCH", `{"name":"membership.example.com","backend":"192.168.1.2:80"}`, http.StatusMethodNotAllowed},
+	}
+	for i, e := range expects {
+		if code := do(e.method, e.body); code != e.code {
+			t.Errorf("the %dth request should return %d but got: %d", i, e.code, code)
+		}
+	}
+
+	// list backends
+	resp, err := http.Get(url + "?name=" + appName)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to list backends: %s", err)
+	}
+	defer resp.Body.Close()
+	status := []backendStatus{}
+	json.NewDecoder(resp.Body).Decode(&status)
+	if len(status) != 1 || status[0].URL != "192.168.1.2:80" || status[0].Weight != 3 {
+		t.Errorf("backends of app is wrong: %+v", status)
+	}
+
+	resp, err = http.Get(url + "?name=what.example.com")
+	if err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("should return 404 for app not exist")
+	}
+}
+
+func TestDrain(t *testing.T) {
+	b1 := NewBackend("192.168.1.1:80", 5)
+	b2 := NewBackend("192.168.1.2:80", 1)
+
+	for _, balancer := range []Balancer{NewWRR(b1, b2), NewRR(b1, b2), NewRdm(b1, b2)} {
+		a := NewApp(balancer, true)
+
+		b1.acquire()
+		status, err := a.Drain("192.168.1.1:80", true)
+		if err != nil || len(status) != 1 || !status[0].Draining || status[0].Inflight != 1 {
+			t.Errorf("backend should be draining with 1 request in flight, but got: %+v, %s", status, err)
+		}
+		b1.release()
+
+		for i := 0; i < 10; i++ {
+			if b, found := balancer.Select(); !found || b.URL != "192.168.1.2:80" {
+				t.Errorf("%T should not select draining backend, but got: %+v, %t", balancer, b, found)
+			}
+		}
+
+		status, err = a.Drain("192.168.1.1:80", false)
+		if err != nil || status[0].Draining || status[0].Inflight != 0 {
+			t.Errorf("backend should not be draining, but got: %+v, %s", status, err)
+		}
+
+		if _, err := a.Drain("192.168.1.3:80", true); err != errBackendNotFound {
+			t.Errorf("should return %s but got: %s", errBackendNotFound, err)
+		}
+	}
+}
+
+func TestDrainHandler(t *testing.T) {
+	fakeServer := httptest.NewServer(http.HandlerFunc(drainHandler))
+	defer fakeServer.Close()
+	url := fakeServer.URL + "/app/backend/drain"
+
+	appName := "drain.example.com"
+	breaker.apps[appName] = NewApp(NewRR(NewBackend("192.168.1.1:80", 1)), true)
+	defer delete(breaker.apps, appName)
+
+	expects := []struct {
+		method string
+		body   string
+		code   int
+	}{
+		{"POST", `{"name":"drain.example.com","backend":"192.168.1.1:80"}`, http.StatusOK},
+		{"DELETE", `{"name":"drain.example.com","backend":"192.168.1.1:80"}`, http.StatusOK},
+		{"POST", `{"name":"drain.example.com","backend":"192.168.1.2:80"}`, http.StatusNotFound},
+		{"POST", `{"name":"what.example.com","backend":"192.168.1.1:80"}`, http.StatusNotFound},
+		{"POST", `what`, http.StatusBadRequest},
+		{"GET", ``, http.StatusMethodNotAllowed},
+	}
+	for i, e := range expects {
+		req, _ := http.NewRequest(e.method, url, bytes.NewBufferString(e.body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to request drain handler: %s", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != e.code {
+			t.Errorf("the %dth request should return %d but got: %d", i, e.code, resp.StatusCode)
+		}
+	}
+}
+
+func TestAPPStatusHandler(t *testing.T) {
+	fakeServer := httptest.NewServer(http.HandlerFunc(appStatusHandler))
+	defer fakeServer.Close()
+	url := fakeServer.URL + "/app/status"
+
+	appName := "status.example.com"
+	breaker.apps[appName] = NewApp(NewRR(NewBackend("192.168.1.1:80", 1)), true)
+	defer delete(breaker.apps, appName)
+
+	resp, err := http.Get(url + "?name=" + appName)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to get status of app: %s", err)
+	}
+	defer resp.Body.Close()
+	status := appStatus{}
+	json.NewDecoder(resp.Body).Decode(&status)
+	if status.Name != appName || status.FailedOver {
+		t.Errorf("status of app is wrong: %+v", status)
+	}
+
+	resp, err = http.Get(url + "?name=what.example.com")
+	if err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("should return 404 for app not exist")
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+registry of HTTP methods, every method has a bit of HTTPMethod, so methods of a route are a
+bitmask. the common methods are built in, and extension methods, e.g. WebDAV's `PROPFIND` or
+`PURGE`, get the free bits when they're registered. registered methods are never removed.
+*/
+
+var (
+	errMethodEmpty    = errors.New("at least one method is required")
+	errBadMethod      = errors.New("bad http method")
+	errBadMethodName  = errors.New("name of extension method should be a token, e.g. PURGE")
+	errTooManyMethods = errors.New("too many extension methods")
+)
+
+// methodTable is replaced as a whole when a method is registered, so it can be read without lock
+type methodTable struct {
+	byName map[string]HTTPMethod
+	names  []string // names[i] is the name of GET << i
+}
+
+var (
+	methodRegistry     atomic.Value // *methodTable
+	methodRegistryLock sync.Mutex   // serializes registering
+)
+
+func init() {
+	t := &methodTable{byName: map[string]HTTPMethod{}}
+	for i, name := range []string{"GET", "POST", "PUT", "DELETE", "HEAD", "OPTIONS", "CONNECT", "TRACE", "PATCH"} {
+		t.byName[name] = GET << uint(i)
+		t.names = append(t.names, name)
+	}
+	methodRegistry.Store(t)
+}
+
+func methodTableNow() *methodTable {
+	return methodRegistry.Load().(*methodTable)
+}
+
+// isToken return true if name is a token of RFC 7230, which a method should be
+func isToken(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if isParamNameByte(c) {
+			continue
+		}
+		switch c {
+		case '!', '#', '$', '%', '&', '\'', '*', '+', '-', '.', '^', '`', '|', '~':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// with return a copy of t in which names are registered too, t itself is unchanged
+func (t *methodTable) with(names ...string) (*methodTable, error) {
+	newTable := &methodTable{byName: make(map[string]HTTPMethod, len(t.byName)+len(names))}
+	for k, v := range t.byName {
+		newTable.byName[k] = v
+	}
+	newTable.names = append([]string{}, t.names...)
+
+	for _, name := range names {
+		if !isToken(name) {
+			return nil, errBadMethodName
+		}
+		if _, exist := newTable.byName[name]; exist {
+			continue
+		}
+
+		method := GET << uint(len(newTable.names))
+		if method == NONE {
+			return nil, errTooManyMethods
+		}
+		newTable.byName[name] = method
+		newTable.names = append(newTable.names, name)
+	}
+
+	return newTable, nil
+}
+
+// RegisterMethod register an extension method and return its bit, it's case-sensitive. the bit
+// is returned if it's registered already
+func RegisterMethod(name string) (HTTPMethod, error) {
+	if !isToken(name) {
+		return NONE, errBadMethodName
+	}
+
+	methodRegistryLock.Lock()
+	defer methodRegistryLock.Unlock()
+
+	t := methodTableNow()
+	if method, exist := t.byName[name]; exist {
+		return method, nil
+	}
+
+	newTable, err := t.with(name)
+	if err != nil {
+		return NONE, err
+	}
+	methodRegistry.Store(newTable)
+
+	return newTable.byName[name], nil
+}
+
+// methodOf return the bit of method name, false if it's not registered
+func methodOf(name []byte) (HTTPMethod, bool) {
+	// the common ones, without looking up the table
+	switch string(name) {
+	case "GET":
+		return GET, true
+	case "POST":
+		return POST, true
+	case "PUT":
+		return PUT, true
+	case "DELETE":
+		return DELETE, true
+	case "HEAD":
+		return HEAD, true
+	case "OPTIONS":
+		return OPTIONS, true
+	}
+
+	method, exist := methodTableNow().byName[string(name)]
+	return method, exist
+}
+
+// convertMethod return methods as a bitmask, or an error if there is no method or one of them
+// is not registered
+func convertMethod(methods ...string) (HTTPMethod, error) {
+	return methodTableNow().convert(methods...)
+}
+
+// convert return methods as a bitmask by t, see convertMethod
+func (t *methodTable) convert(methods ...string) (HTTPMethod, error) {
+	if len(methods) == 0 {
+		return NONE, errMethodEmpty
+	}
+
+	httpMethods := NONE
+	for _, m := range methods {
+		method, exist := t.byName[m]
+		if !exist {
+			return NONE, errBadMethod
+		}
+		httpMethods |= method
+	}
+
+	return httpMethods, nil
+}
+
+// appendAllow append methods to buf as the value of header Allow, e.g. `GET, POST`
+func appendAllow(buf []byte, methods HTTPMethod) []byte {
+	start := len(buf)
+	for i, name := range methodTableNow().names {
+		if methods&(GET<<uint(i)) == 0 {
+			continue
+		}
+		if len(buf) > start {
+			buf = append(buf, ", "...)
+		}
+		buf = append(buf, name...)
+	}
+
+	return buf
+}
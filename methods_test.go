@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestRegisterMethod(t *testing.T) {
+	for _, name := range []string{"", "PROP FIND", "PURGE\n", "(GET)"} {
+		if _, err := RegisterMethod(name); err != errBadMethodName {
+			t.Errorf("%q should not be registered but got: %v", name, err)
+		}
+	}
+
+	if method, err := RegisterMethod("GET"); err != nil || method != GET {
+		t.Errorf("GET should be built in, but got: %d, %v", method, err)
+	}
+
+	propfind, err := RegisterMethod("PROPFIND")
+	if err != nil || propfind <= PATCH {
+		t.Fatalf("PROPFIND should get a free bit, but got: %d, %v", propfind, err)
+	}
+	if method, _ := RegisterMethod("PROPFIND"); method != propfind {
+		t.Errorf("PROPFIND should be registered once, but got: %d", method)
+	}
+	if method, known := methodOf([]byte("PROPFIND")); !known || method != propfind {
+		t.Errorf("PROPFIND should be known, but got: %d, %t", method, known)
+	}
+	if _, known := methodOf([]byte("propfind")); known {
+		t.Errorf("methods should be case-sensitive")
+	}
+
+	methods, err := convertMethod("GET", "PROPFIND")
+	if err != nil || methods != GET|propfind {
+		t.Errorf("methods should be converted, but got: %d, %v", methods, err)
+	}
+	if allow := string(appendAllow(nil, methods)); allow != "GET, PROPFIND" {
+		t.Errorf("allow should be GET, PROPFIND, but got: %s", allow)
+	}
+}
+
+func TestMethodOf(t *testing.T) {
+	for _, name := range []string{"GET", "POST", "PUT", "DELETE", "HEAD", "OPTIONS", "CONNECT", "TRACE", "PATCH"} {
+		method, known := methodOf([]byte(name))
+		if converted, _ := convertMethod(name); !known || method != converted {
+			t.Errorf("%s should be %d, but got: %d, %t", name, converted, method, known)
+		}
+	}
+
+	if _, known := methodOf([]byte("BREW")); known {
+		t.Errorf("BREW should not be known")
+	}
+}
+
+func TestExtensionMethods(t *testing.T) {
+	backend, stop := inmemoryBackend(t, func(ctx *fasthttp.RequestCtx) {
+		ctx.Write(ctx.Method())
+	})
+	defer stop()
+
+	config := &appConfig{Name: "www.example.com", ExtensionMethods: []string{"purge", "mkcol"}}
+	if err := checkAppConfig(config); err != nil {
+		t.Fatalf("should not return error but got: %s", err)
+	}
+	if config.ExtensionMethods[0] != "PURGE" {
+		t.Errorf("extension methods should be upper case, but got: %s", config.ExtensionMethods[0])
+	}
+
+	a, err := getAPP(config)
+	if err != nil {
+		t.Fatalf("should not return error but got: %s", err)
+	}
+	defer a.Close()
+	a.balancer = NewRR(backend)
+	if err := a.AddRoute("/cache/*path", "GET", "PURGE"); err != nil {
+		t.Fatalf("should not return error but got: %s", err)
+	}
+
+	expects := []struct {
+		method string
+		code   int
+		allow  string
+	}{
+		{"PURGE", fasthttp.StatusOK, ""},
+		{"MKCOL", fasthttp.StatusMethodNotAllowed, "GET, PURGE"},
+		{"BREW", fasthttp.StatusNotImplemented, ""},
+	}
+	for _, e := range expects {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(e.method)
+		ctx.Request.SetRequestURI("http://example.com/cache/a.css")
+		a.ServeHTTP(ctx)
+
+		if code := ctx.Response.StatusCode(); code != e.code {
+			t.Errorf("%s should return %d but got: %d", e.method, e.code, code)
+		}
+		if allow := string(ctx.Response.Header.Peek("Allow")); allow != e.allow {
+			t.Errorf("%s should be answered with Allow %s but got: %s", e.method, e.allow, allow)
+		}
+	}
+}
+
+func TestExtensionMethodsRejected(t *testing.T) {
+	// paths are checked with extension methods, but nothing is registered
+	config := &appConfig{
+		Name: "www.example.com", ExtensionMethods: []string{"unlock", "bad method"},
+		Paths: []string{"/files/*path"}, Methods: []string{"unlock"},
+	}
+	if err := checkAppConfig(config); err != errBadMethodName {
+		t.Errorf("should return %s but got: %v", errBadMethodName, err)
+	}
+	if _, known := methodOf([]byte("UNLOCK")); known {
+		t.Errorf("methods of a rejected config should not be registered")
+	}
+
+	config = &appConfig{Name: "www.example.com", ExtensionMethods: []string{"unlock"}, Paths: []string{"/files/*path"}, Methods: []string{"lock"}}
+	if err := checkAppConfig(config); err == nil {
+		t.Errorf("LOCK should not be known")
+	}
+	if _, known := methodOf([]byte("UNLOCK")); known {
+		t.Errorf("methods of a rejected config should not be registered")
+	}
+
+	config.Methods = []string{"unlock"}
+	if err := checkAppConfig(config); err != nil {
+		t.Errorf("should not return error but got: %s", err)
+	}
+	if _, known := methodOf([]byte("UNLOCK")); known {
+		t.Errorf("methods should not be registered before the app is built")
+	}
+}
+
+func TestExtensionMethodsHostExists(t *testing.T) {
+	fakeServer := httptest.NewServer(http.HandlerFunc(appHandler))
+	defer fakeServer.Close()
+
+	running := NewApp(NewRR(), true)
+	running.hosts = []string{"*.methods.example.com"}
+	breaker.setApp("methods.example.com", running)
+	defer delete(breaker.apps, "methods.example.com")
+
+	// the config is checked, but it's rejected while being set
+	config := `{"name":"other.example.org","hosts":["*.methods.example.com"],"backends":["127.0.0.1:80"],"weights":[1],"paths":["/"],"methods":["ZZFOO"],"extension_methods":["ZZFOO"]}`
+	resp, err := http.Post(fakeServer.URL+"/app", "application/json", bytes.NewBufferString(config))
+	if err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("should return 400, but got: %v, %v", resp, err)
+	}
+	resp.Body.Close()
+
+	if _, known := methodOf([]byte("ZZFOO")); known {
+		t.Errorf("methods of a rejected config should not be registered")
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+/*
+automatic OPTIONS, OPTIONS requests for a route which doesn't allow OPTIONS are answered by guard
+with methods of the route instead of being proxied. a CORS preflight request, whose origin is
+allowed and whose requested method is allowed by the route, is answered with CORS headers too.
+*/
+
+var errBadOptionsMaxAge = errors.New("max age of auto options should not be negative")
+
+type optionsConfig struct {
+	AllowOrigins []string `json:"allow_origins,omitempty"` // e.g. ["https://example.com"], or ["*"] for any origin
+	AllowHeaders []string `json:"allow_headers,omitempty"` // e.g. ["Content-Type"], request headers allowed by CORS
+	MaxAge       int      `json:"max_age,omitempty"`       // in seconds, how long preflight can be cached
+}
+
+func checkOptionsConfig(c *optionsConfig) error {
+	if c.MaxAge < 0 {
+		return errBadOptionsMaxAge
+	}
+
+	return nil
+}
+
+// autoOptions answers OPTIONS requests, header values are built once
+type autoOptions struct {
+	anyOrigin bool
+	origins   [][]byte
+	headers   []byte // empty if no header is allowed
+	maxAge    []byte // empty if it's not set
+}
+
+func newAutoOptions(c *optionsConfig) *autoOptions {
+	if c == nil {
+		return nil
+	}
+
+	o := &autoOptions{headers: []byte(strings.Join(c.AllowHeaders, ", "))}
+	for _, origin := range c.AllowOrigins {
+		if origin == "*" {
+			o.anyOrigin = true
+		}
+		o.origins = append(o.origins, []byte(origin))
+	}
+	if c.MaxAge > 0 {
+		o.maxAge = []byte(strconv.Itoa(c.MaxAge))
+	}
+
+	return o
+}
+
+func (o *autoOptions) allowOrigin(origin []byte) bool {
+	if o.anyOrigin {
+		return true
+	}
+	for _, allowed := range o.origins {
+		if bytes.Equal(allowed, origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setAllow set header Allow of the response to methods
+func setAllow(ctx *fasthttp.RequestCtx, methods HTTPMethod) {
+	bufp := acquireBuf()
+	allow := appendAllow(*bufp, methods)
+	ctx.Response.Header.SetBytesV("Allow", allow)
+	*bufp = allow
+	releaseBuf(bufp)
+}
+
+// answer the OPTIONS request for a route which allows methods
+func (o *autoOptions) answer(ctx *fasthttp.RequestCtx, methods HTTPMethod) {
+	methods |= OPTIONS
+	bufp := acquireBuf()
+	allow := appendAllow(*bufp, methods)
+	defer func() {
+		*bufp = allow
+		releaseBuf(bufp)
+	}()
+
+	header := &ctx.Response.Header
+	header.SetBytesV("Allow", allow)
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
+
+	origin := ctx.Request.Header.Peek("Origin")
+	requested := ctx.Request.Header.Peek("Access-Control-Request-Method")
+	if len(origin) == 0 || len(requested) == 0 || !o.allowOrigin(origin) {
+		return
+	}
+	if method, known := methodOf(requested); !known || methods&method == 0 {
+		return
+	}
+
+	if o.anyOrigin {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.SetBytesV("Access-Control-Allow-Origin", origin)
+		header.Add("Vary", "Origin")
+	}
+	header.SetBytesV("Access-Control-Allow-Methods", allow)
+	if len(o.headers) > 0 {
+		header.SetBytesV("Access-Control-Allow-Headers", o.headers)
+	}
+	if len(o.maxAge) > 0 {
+		header.SetBytesV("Access-Control-Max-Age", o.maxAge)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestAppendAllow(t *testing.T) {
+	expects := []struct {
+		methods HTTPMethod
+		allow   string
+	}{
+		{NONE, ""},
+		{GET, "GET"},
+		{GET | POST | DELETE, "GET, POST, DELETE"},
+		{PATCH | OPTIONS | HEAD, "HEAD, OPTIONS, PATCH"},
+	}
+	for _, e := range expects {
+		if allow := string(appendAllow(nil, e.methods)); allow != e.allow {
+			t.Errorf("allow of %d should be %s, but got: %s", e.methods, e.allow, allow)
+		}
+	}
+}
+
+func TestCheckOptionsConfig(t *testing.T) {
+	if err := checkOptionsConfig(&optionsConfig{MaxAge: 60}); err != nil {
+		t.Errorf("should not return error but got: %s", err)
+	}
+	if err := checkOptionsConfig(&optionsConfig{MaxAge: -1}); err != errBadOptionsMaxAge {
+		t.Errorf("should return %s but got: %v", errBadOptionsMaxAge, err)
+	}
+}
+
+func TestMethodNotAllowedAllow(t *testing.T) {
+	a := NewApp(NewRR(), true)
+	a.AddRoute("/user/:name", "GET", "DELETE")
+
+	for _, method := range []string{"POST", "OPTIONS"} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(method)
+		ctx.Request.SetRequestURI("/user/jhon")
+		a.ServeHTTP(ctx)
+
+		if code := ctx.Response.StatusCode(); code != fasthttp.StatusMethodNotAllowed {
+			t.Errorf("%s should return 405 but got: %d", method, code)
+		}
+		if allow := string(ctx.Response.Header.Peek("Allow")); allow != "GET, DELETE" {
+			t.Errorf("%s should be answered with Allow but got: %s", method, allow)
+		}
+	}
+}
+
+func TestAutoOptions(t *testing.T) {
+	backend, stop := inmemoryBackend(t, func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString("proxied")
+	})
+	defer stop()
+
+	a := NewApp(NewRR(backend), true)
+	a.AddRoute("/user/:name", "GET", "DELETE")
+	a.AddRoute("/cors", "GET", "OPTIONS")
+	a.options = newAutoOptions(&optionsConfig{
+		AllowOrigins: []string{"https://example.com"}, AllowHeaders: []string{"Content-Type", "X-Token"}, MaxAge: 60,
+	})
+
+	options := func(path, origin, method string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod("OPTIONS")
+		ctx.Request.SetRequestURI("http://example.com" + path)
+		if origin != "" {
+			ctx.Request.Header.Set("Origin", origin)
+			ctx.Request.Header.Set("Access-Control-Request-Method", method)
+		}
+		a.ServeHTTP(ctx)
+		return ctx
+	}
+
+	// answered with Allow
+	ctx := options("/user/jhon", "", "")
+	if code := ctx.Response.StatusCode(); code != fasthttp.StatusNoContent {
+		t.Errorf("should return 204 but got: %d", code)
+	}
+	if allow := string(ctx.Response.Header.Peek("Allow")); allow != "GET, DELETE, OPTIONS" {
+		t.Errorf("should be answered with Allow but got: %s", allow)
+	}
+	if origin := ctx.Response.Header.Peek("Access-Control-Allow-Origin"); origin != nil {
+		t.Errorf("should not be answered with CORS headers but got: %s", origin)
+	}
+
+	// preflight
+	ctx = options("/user/jhon", "https://example.com", "DELETE")
+	header := &ctx.Response.Header
+	for k, v := range map[string]string{
+		"Access-Control-Allow-Origin":  "https://example.com",
+		"Access-Control-Allow-Methods": "GET, DELETE, OPTIONS",
+		"Access-Control-Allow-Headers": "Content-Type, X-Token",
+		"Access-Control-Max-Age":       "60",
+		"Vary":                         "Origin",
+	} {
+		if value := string(header.Peek(k)); value != v {
+			t.Errorf("%s should be %s but got: %s", k, v, value)
+		}
+	}
+
+	// origin or method is not allowed
+	for _, e := range [][2]string{{"https://evil.com", "GET"}, {"https://example.com", "PUT"}} {
+		ctx = options("/user/jhon", e[0], e[1])
+		if origin := ctx.Response.Header.Peek("Access-Control-Allow-Origin"); origin != nil {
+			t.Errorf("%s %s should not be allowed but got: %s", e[0], e[1], origin)
+		}
+	}
+
+	// routes which allow OPTIONS are proxied
+	ctx = options("/cors", "", "")
+	if body := string(ctx.Response.Body()); body != "proxied" {
+		t.Errorf("OPTIONS should be proxied but got: %s", body)
+	}
+
+	// any origin
+	a.options = newAutoOptions(&optionsConfig{AllowOrigins: []string{"*"}})
+	ctx = options("/user/jhon", "https://evil.com", "GET")
+	if origin := string(ctx.Response.Header.Peek("Access-Control-Allow-Origin")); origin != "*" {
+		t.Errorf("any origin should be allowed but got: %s", origin)
+	}
+	if headers := ctx.Response.Header.Peek("Access-Control-Allow-Headers"); headers != nil {
+		t.Errorf("no header should be allowed but got: %s", headers)
+	}
+}
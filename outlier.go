@@ -0,0 +1,254 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+passive outlier detection, it's similar to Envoy's outlier detection:
+https://www.envoyproxy.io/docs/envoy/latest/intro/arch_overview/upstream/outlier
+
+every response proxied by `Proxy` is fed back to the backend it comes from, a backend
+which fails too many times in a row, or whose error ratio is too high, will be ejected
+from the pool for a while. the more times it's ejected, the longer it will be ejected.
+*/
+
+const (
+	defaultConsecutiveFailures = 5
+	defaultOutlierMinRequests  = 10
+	defaultBaseEjectionTime    = 30  // seconds
+	defaultMaxEjectionTime     = 300 // seconds
+	defaultMaxEjectionPercent  = 10
+)
+
+type outlierConfig struct {
+	ConsecutiveFailures uint32  `json:"consecutive_failures"` // eject after N failures in a row, 0 means 5
+	ErrorRatio          float64 `json:"error_ratio"`          // eject if error ratio is above it, 0 means disabled
+	MinRequests         uint32  `json:"min_requests"`         // requests required before error ratio is considered
+	BaseEjectionTime    int64   `json:"base_ejection_time"`   // in seconds, doubled on each repeat ejection
+	MaxEjectionTime     int64   `json:"max_ejection_time"`    // in seconds
+	MaxEjectionPercent  int     `json:"max_ejection_percent"` // at most how many percent of backends can be ejected
+}
+
+func checkOutlierConfig(c *outlierConfig) error {
+	if c.ErrorRatio < 0 || c.ErrorRatio > 1 {
+		return errBadOutlierErrorRatio
+	}
+	if c.MaxEjectionPercent < 0 || c.MaxEjectionPercent > 100 {
+		return errBadOutlierEjectionPercent
+	}
+	if c.BaseEjectionTime < 0 || c.MaxEjectionTime < 0 {
+		return errBadOutlierEjectionTime
+	}
+
+	if c.ConsecutiveFailures == 0 {
+		c.ConsecutiveFailures = defaultConsecutiveFailures
+	}
+	if c.MinRequests == 0 {
+		c.MinRequests = defaultOutlierMinRequests
+	}
+	if c.BaseEjectionTime == 0 {
+		c.BaseEjectionTime = defaultBaseEjectionTime
+	}
+	if c.MaxEjectionTime == 0 {
+		c.MaxEjectionTime = defaultMaxEjectionTime
+	}
+	if c.MaxEjectionTime < c.BaseEjectionTime {
+		c.MaxEjectionTime = c.BaseEjectionTime
+	}
+	if c.MaxEjectionPercent == 0 {
+		c.MaxEjectionPercent = defaultMaxEjectionPercent
+	}
+
+	return nil
+}
+
+// backendStats is shared by all copies of a `Backend`, it counts responses of the
+// backend in the current time window, like `Status` does for a route.
+type backendStats struct {
+	url       string
+	detector  *outlierDetector // nil if outlier detection is disabled
+	slowStart *slowStartConfig // nil if slow start is disabled
+	addedAt   int64            // unix timestamp, 0 if it's there since the app was built
+
+	key                 int64 // current window, see `RightNow`
+	requests            uint32
+	failures            uint32
+	latency             uint64 // sum of latency in the current window, in microseconds
+	consecutiveFailures uint32
+
+	ejectedUntil int64 // unix timestamp, the backend is ejected before it
+	ejections    uint32
+
+	draining uint32 // non-zero if the backend is draining, see `Application.Drain`
+	inflight int64  // requests which are being proxied to the backend
+}
+
+func isFailure(code int) bool {
+	return code >= 500
+}
+
+// refresh resets counters if the window is outdate, though it may cause some dirty reads
+func (s *backendStats) refresh(now int64) {
+	key := atomic.LoadInt64(&s.key)
+	if key != now && atomic.CompareAndSwapInt64(&s.key, key, now) {
+		atomic.StoreUint32(&s.requests, 0)
+		atomic.StoreUint32(&s.failures, 0)
+		atomic.StoreUint64(&s.latency, 0)
+	}
+}
+
+// report records the response of a request
+func (s *backendStats) report(code int, latency time.Duration) {
+	s.refresh(RightNow())
+
+	requests := atomic.AddUint32(&s.requests, 1)
+	atomic.AddUint64(&s.latency, uint64(latency/time.Microsecond))
+
+	if !isFailure(code) {
+		atomic.StoreUint32(&s.consecutiveFailures, 0)
+		return
+	}
+
+	failures := atomic.AddUint32(&s.failures, 1)
+	consecutive := atomic.AddUint32(&s.consecutiveFailures, 1)
+
+	if d := s.detector; d != nil && d.shouldEject(requests, failures, consecutive) {
+		d.eject(s)
+	}
+}
+
+// query return requests, failures, average latency in the current window
+func (s *backendStats) query() (uint32, uint32, time.Duration) {
+	s.refresh(RightNow())
+
+	requests := atomic.LoadUint32(&s.requests)
+	failures := atomic.LoadUint32(&s.failures)
+	latency := atomic.LoadUint64(&s.latency)
+
+	if requests == 0 {
+		return 0, 0, 0
+	}
+
+	return requests, failures, time.Duration(latency/uint64(requests)) * time.Microsecond
+}
+
+func (s *backendStats) isEjected(now int64) bool {
+	return atomic.LoadInt64(&s.ejectedUntil) > now
+}
+
+// outlierDetector decides whether a backend of a pool should be ejected or not
+type outlierDetector struct {
+	lock sync.Mutex
+
+	config outlierConfig
+	pool   []*backendStats
+}
+
+func newOutlierDetector(config *outlierConfig) *outlierDetector {
+	if config == nil {
+		return nil
+	}
+
+	return &outlierDetector{config: *config}
+}
+
+// watch add backends to the pool which is managed by the detector
+func (d *outlierDetector) watch(backends ...Backend) {
+	if d == nil {
+		return
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for _, b := range backends {
+		if b.stats == nil {
+			continue
+		}
+		b.stats.detector = d
+		d.pool = append(d.pool, b.stats)
+	}
+}
+
+// unwatch remove backends from the pool which is managed by the detector
+func (d *outlierDetector) unwatch(backends ...Backend) {
+	if d == nil {
+		return
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	pool := d.pool[:0]
+	for _, s := range d.pool {
+		removed := false
+		for _, b := range backends {
+			if b.stats == s {
+				removed = true
+				break
+			}
+		}
+		if !removed {
+			pool = append(pool, s)
+		}
+	}
+	d.pool = pool
+}
+
+func (d *outlierDetector) shouldEject(requests, failures, consecutive uint32) bool {
+	if consecutive >= d.config.ConsecutiveFailures {
+		return true
+	}
+
+	return d.config.ErrorRatio > 0 && requests >= d.config.MinRequests &&
+		float64(failures)/float64(requests) > d.config.ErrorRatio
+}
+
+// eject the backend, if too many backends are ejected, it does nothing
+func (d *outlierDetector) eject(s *backendStats) {
+	now := CoarseTimeNow().Unix()
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if s.isEjected(now) {
+		return
+	}
+
+	ejected := 0
+	for _, p := range d.pool {
+		if p.isEjected(now) {
+			ejected++
+		}
+	}
+	// allow to eject at least one backend if there are more than one
+	allowed := len(d.pool) * d.config.MaxEjectionPercent / 100
+	if allowed < 1 && len(d.pool) > 1 {
+		allowed = 1
+	}
+	if ejected >= allowed {
+		return
+	}
+
+	// backend has been healthy for a long time, forget its history
+	if now-atomic.LoadInt64(&s.ejectedUntil) > d.config.MaxEjectionTime {
+		atomic.StoreUint32(&s.ejections, 0)
+	}
+
+	ejections := atomic.AddUint32(&s.ejections, 1)
+	duration := d.config.BaseEjectionTime
+	for i := uint32(1); i < ejections && duration < d.config.MaxEjectionTime; i++ {
+		duration *= 2
+	}
+	if duration > d.config.MaxEjectionTime {
+		duration = d.config.MaxEjectionTime
+	}
+
+	atomic.StoreInt64(&s.ejectedUntil, now+duration)
+	atomic.StoreUint32(&s.consecutiveFailures, 0)
+	log.Printf("backend %s is ejected for %d seconds, it's the %dth time", s.url, duration, ejections)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCheckOutlierConfig(t *testing.T) {
+	c := &outlierConfig{}
+	if err := checkOutlierConfig(c); err != nil {
+		t.Errorf("should not return error but got: %s", err)
+	}
+	if c.ConsecutiveFailures != defaultConsecutiveFailures || c.MinRequests != defaultOutlierMinRequests ||
+		c.BaseEjectionTime != defaultBaseEjectionTime || c.MaxEjectionTime != defaultMaxEjectionTime ||
+		c.MaxEjectionPercent != defaultMaxEjectionPercent {
+		t.Errorf("outlier config should be set to default, but got: %+v", c)
+	}
+
+	c = &outlierConfig{BaseEjectionTime: 100, MaxEjectionTime: 10}
+	checkOutlierConfig(c)
+	if c.MaxEjectionTime != 100 {
+		t.Errorf("max ejection time should not be less than base ejection time, but got: %+v", c)
+	}
+
+	for _, c := range []*outlierConfig{
+		&outlierConfig{ErrorRatio: 1.5},
+		&outlierConfig{ErrorRatio: -1},
+		&outlierConfig{MaxEjectionPercent: 101},
+		&outlierConfig{BaseEjectionTime: -1},
+	} {
+		if err := checkOutlierConfig(c); err == nil {
+			t.Errorf("should return error but not: %+v", c)
+		}
+	}
+}
+
+func newOutlierPool(config outlierConfig, urls ...string) []Backend {
+	checkOutlierConfig(&config)
+
+	backends := []Backend{}
+	for _, url := range urls {
+		backends = append(backends, NewBackend(url, 1))
+	}
+	newOutlierDetector(&config).watch(backends...)
+
+	return backends
+}
+
+func TestOutlierConsecutiveFailures(t *testing.T) {
+	backends := newOutlierPool(
+		outlierConfig{ConsecutiveFailures: 3, MaxEjectionPercent: 50},
+		"192.168.1.1:80", "192.168.1.2:80",
+	)
+	b := &backends[0]
+	now := CoarseTimeNow().Unix()
+
+	b.report(http.StatusBadGateway, time.Millisecond)
+	b.report(http.StatusBadGateway, time.Millisecond)
+	b.report(http.StatusOK, time.Millisecond)
+	b.report(http.StatusBadGateway, time.Millisecond)
+	b.report(http.StatusBadGateway, time.Millisecond)
+	if !b.available(now) {
+		t.Errorf("backend should not be ejected because failures are not in a row")
+	}
+
+	b.report(http.StatusBadGateway, time.Millisecond)
+	if b.available(now) {
+		t.Errorf("backend should be ejected after 3 failures in a row")
+	}
+
+	requests, failures, latency := b.stats.query()
+	if requests != 6 || failures != 5 || latency != time.Millisecond {
+		t.Errorf("stats of backend is wrong: %d, %d, %s", requests, failures, latency)
+	}
+}
+
+func TestOutlierErrorRatio(t *testing.T) {
+	backends := newOutlierPool(
+		outlierConfig{ConsecutiveFailures: 100, ErrorRatio: 0.5, MinRequests: 10, MaxEjectionPercent: 50},
+		"192.168.1.1:80", "192.168.1.2:80",
+	)
+	b := &backends[0]
+	now := CoarseTimeNow().Unix()
+
+	for i := 0; i < 4; i++ {
+		b.report(http.StatusOK, time.Millisecond)
+		b.report(http.StatusInternalServerError, time.Millisecond)
+	}
+	if !b.available(now) {
+		t.Errorf("backend should not be ejected before min requests is reached")
+	}
+
+	b.report(http.StatusInternalServerError, time.Millisecond)
+	b.report(http.StatusInternalServerError, time.Millisecond)
+	if b.available(now) {
+		t.Errorf("backend should be ejected because error ratio is too high")
+	}
+}
+
+func TestOutlierMaxEjectionPercent(t *testing.T) {
+	backends := newOutlierPool(
+		outlierConfig{ConsecutiveFailures: 1, MaxEjectionPercent: 10},
+		"192.168.1.1:80", "192.168.1.2:80", "192.168.1.3:80",
+	)
+	now := CoarseTimeNow().Unix()
+
+	for i := range backends {
+		backends[i].report(http.StatusBadGateway, time.Millisecond)
+	}
+
+	ejected := 0
+	for i := range backends {
+		if !backends[i].available(now) {
+			ejected++
+		}
+	}
+	if ejected != 1 {
+		t.Errorf("only one backend should be ejected, but got: %d", ejected)
+	}
+
+	// the only backend should never be ejected
+	backends = newOutlierPool(outlierConfig{ConsecutiveFailures: 1}, "192.168.1.1:80")
+	backends[0].report(http.StatusBadGateway, time.Millisecond)
+	if !backends[0].available(now) {
+		t.Errorf("the only backend should not be ejected")
+	}
+}
+
+func TestOutlierEjectionBackoff(t *testing.T) {
+	backends := newOutlierPool(
+		outlierConfig{ConsecutiveFailures: 1, BaseEjectionTime: 10, MaxEjectionTime: 30, MaxEjectionPercent: 50},
+		"192.168.1.1:80", "192.168.1.2:80",
+	)
+	s := backends[0].stats
+
+	for _, expected := range []int64{10, 20, 30, 30} {
+		// pretend the last ejection is just over
+		s.ejectedUntil = CoarseTimeNow().Unix()
+		backends[0].report(http.StatusBadGateway, time.Millisecond)
+
+		// coarse time may tick in the meanwhile
+		if duration := s.ejectedUntil - CoarseTimeNow().Unix(); duration != expected && duration != expected-1 {
+			t.Errorf("backend should be ejected for %d seconds, but got: %d", expected, duration)
+		}
+	}
+}
+
+func TestBalancerSkipEjected(t *testing.T) {
+	backends := newOutlierPool(
+		outlierConfig{ConsecutiveFailures: 1, MaxEjectionPercent: 50},
+		"192.168.1.1:80", "192.168.1.2:80",
+	)
+	backends[0].report(http.StatusBadGateway, time.Millisecond)
+
+	for _, balancer := range []Balancer{NewWRR(backends...), NewRR(backends...), NewRdm(backends...)} {
+		for i := 0; i < 10; i++ {
+			if b, found := balancer.Select(); !found || b.URL != "192.168.1.2:80" {
+				t.Errorf("%T should skip the ejected backend, but got: %+v, %t", balancer, b, found)
+			}
+		}
+	}
+
+	// nothing available
+	backends[1].stats.ejectedUntil = CoarseTimeNow().Unix() + 10
+	for _, balancer := range []Balancer{NewWRR(backends...), NewRR(backends...), NewRdm(backends...)} {
+		if _, found := balancer.Select(); found {
+			t.Errorf("%T should not found any backend", balancer)
+		}
+	}
+}
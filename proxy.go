@@ -6,12 +6,14 @@ proxy server
 
 import (
 	"log"
+	"time"
 
 	"github.com/valyala/fasthttp"
 )
 
 // Proxy use fasthttp: https://github.com/valyala/fasthttp/issues/64
-func Proxy(balancer Balancer, ctx *fasthttp.RequestCtx) int {
+// the request fails if the backend does not respond in timeout, 0 means no timeout.
+func Proxy(balancer Balancer, ctx *fasthttp.RequestCtx, timeout time.Duration) int {
 	backend, found := balancer.Select()
 	if !found {
 		ctx.SetStatusCode(fasthttp.StatusForbidden)
@@ -26,13 +28,26 @@ func Proxy(balancer Balancer, ctx *fasthttp.RequestCtx) int {
 	req.Header.Del("Connection")
 
 	// proxy
-	if err := client.Do(req, resp); err != nil {
+	start := time.Now()
+	backend.acquire()
+	var err error
+	if timeout > 0 {
+		err = client.DoTimeout(req, resp, timeout)
+	} else {
+		err = client.Do(req, resp)
+	}
+	backend.release()
+	if err != nil {
 		log.Printf("failed to proxy: %s", err)
+		backend.report(fasthttp.StatusBadGateway, time.Since(start))
 		return fasthttp.StatusBadGateway
 	}
 
 	// after
 	resp.Header.Del("Connection")
 
-	return resp.StatusCode()
+	code := resp.StatusCode()
+	backend.report(code, time.Since(start))
+
+	return code
 }
@@ -27,6 +27,16 @@ func (b fakeBalancer) Select() (*Backend, bool) {
 	return &fakeBackend, true
 }
 
+func (b fakeBalancer) Backends() []Backend {
+	return []Backend{fakeBackend}
+}
+
+func (b fakeBalancer) Update(backends ...Backend) {}
+
+func (b fakeBalancer) Failovers() uint64 {
+	return 0
+}
+
 func fakeHandler(ctx *fasthttp.RequestCtx) {
 	ctx.WriteString("hoho!")
 }
@@ -43,7 +53,7 @@ func TestProxyBackendNotFound(t *testing.T) {
 
 	ctx := &fasthttp.RequestCtx{}
 	ctx.Request.SetRequestURI("/")
-	Proxy(fb, ctx)
+	Proxy(fb, ctx, 0)
 
 	if code := ctx.Response.StatusCode(); code != fasthttp.StatusForbidden {
 		t.Errorf("response code should be %d but got: %d", fasthttp.StatusForbidden, code)
@@ -65,9 +75,31 @@ func TestProxyWorks(t *testing.T) {
 	ctx := &fasthttp.RequestCtx{}
 	// RequestURI is used first if it's not empty: https://github.com/valyala/fasthttp/issues/114
 	ctx.Request.SetRequestURI("http://" + u.Host + "/")
-	Proxy(fb, ctx)
+	Proxy(fb, ctx, 0)
 
 	if code := ctx.Response.StatusCode(); code != fasthttp.StatusOK {
 		t.Errorf("response code should be %d but got: %d", fasthttp.StatusOK, code)
 	}
 }
+
+func TestProxyFeedback(t *testing.T) {
+	fakeServer := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusInternalServerError) }),
+	)
+	defer fakeServer.Close()
+
+	u, _ := url.ParseRequestURI(fakeServer.URL)
+	backend := NewBackend(u.Host, 1)
+	balancer := NewRR(backend)
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("http://" + u.Host + "/")
+	if code := Proxy(balancer, ctx, 0); code != fasthttp.StatusInternalServerError {
+		t.Errorf("response code should be %d but got: %d", fasthttp.StatusInternalServerError, code)
+	}
+
+	requests, failures, _ := backend.stats.query()
+	if requests != 1 || failures != 1 || backend.stats.inflight != 0 {
+		t.Errorf("response should be reported to backend, but got: %+v", backend.stats)
+	}
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"log"
+	"sync"
 )
 
 // radix tree, combine with timeline
@@ -45,9 +47,10 @@ const (
 )
 
 // HTTPMethod is HTTP method
-type HTTPMethod uint16
+type HTTPMethod uint64
 
-// HTTP Methods: https://developer.mozilla.org/en-US/docs/Web/HTTP/Methods
+// HTTP Methods: https://developer.mozilla.org/en-US/docs/Web/HTTP/Methods, extension methods
+// take the bits after PATCH when they're registered
 const (
 	NONE HTTPMethod = 0 // means no method had set
 	GET  HTTPMethod = 1 << iota
@@ -61,8 +64,37 @@ const (
 	PATCH
 )
 
-// radix tree is "read only" after constructed. which means, it's not read only
-// but I assume it is...
+var (
+	errWildcardInName     = errors.New("wildcards ':' or '*' are not allowed in param names")
+	errWildcardNameEmpty  = errors.New("wildcards must be named with a non-empty name")
+	errConstraintNotEnd   = errors.New("constraint of wildcard should end with '>'")
+	errBadConstraint      = errors.New("bad constraint of wildcard")
+	errWildcardConflict   = errors.New("wildcard conflicts with the existing one")
+	errCatchAllNotAtEnd   = errors.New("catchAll routers are only allowed once at the end of the path")
+	errCatchAllConstraint = errors.New("catchAll routers can not have constraint")
+	errCatchAllNoSlash    = errors.New("no / before catchAll")
+)
+
+// pathError is a problem of path found when it's added to radix tree
+type pathError struct {
+	path   string
+	err    error
+	detail string // e.g. the existing wildcard which conflicts with path
+}
+
+func newPathError(path []byte, err error, detail []byte) *pathError {
+	return &pathError{path: string(path), err: err, detail: string(detail)}
+}
+
+func (e *pathError) Error() string {
+	if e.detail == "" {
+		return "bad path " + e.path + ": " + e.err.Error()
+	}
+	return "bad path " + e.path + ": " + e.err.Error() + ": " + e.detail
+}
+
+// radix tree is "read only" after constructed. routes of a running application are changed
+// on a copy of the tree, and then the copy replaces it.
 type node struct {
 	noCopy noCopy
 	path   []byte   // common prefix of childs
@@ -70,11 +102,14 @@ type node struct {
 	// supported HTTP methods, for decide raise a `405 Method Not Allowd` or not,
 	// if a method is support, the correspoding bit is set
 	methods   HTTPMethod
-	wildChild bool    // child type is param, or catchAll
+	wildChild bool    // some children are param, or catchAll, they're after the static ones
 	indices   []byte  // first letter of childs, it's index for binary search.
 	children  []*node // childrens
 	isLeaf    bool    // if it's a leaf
 	status    *Status // if it's a leaf, it should have a ring of `Status` struct
+	route     *route  // if it's a leaf, it's the route it belongs to
+
+	constraint *constraint // if it's a param, segments should satisfy it, nil means any segment
 }
 
 func min(a, b int) int {
@@ -96,9 +131,76 @@ func (n *node) hasMethod(method HTTPMethod) bool {
 	return method == (method & n.methods)
 }
 
+// wildChildren return children which are wildcards, params are in front of catchAll, and
+// params with constraint are in front of the one without constraint
+func (n *node) wildChildren() []*node {
+	if n.nType == param {
+		return nil
+	}
+	return n.children[len(n.indices):]
+}
+
+// addWildChild add child which is a wildcard after the static children of n, and keep the order
+// of wildcards. there can be only one catchAll and one param without constraint
+func (n *node) addWildChild(child *node, fullPath []byte) error {
+	wildChildren := n.wildChildren()
+	pos := len(n.children)
+	for i, c := range wildChildren {
+		if c.nType == child.nType && (c.nType == catchAll || (c.constraint == nil && child.constraint == nil)) {
+			return newPathError(fullPath, errWildcardConflict, c.path)
+		}
+
+		// insert param before catchAll, or param with constraint before the one without
+		if pos == len(n.children) && child.nType == param &&
+			(c.nType == catchAll || (c.constraint == nil && child.constraint != nil)) {
+			pos = len(n.indices) + i
+		}
+	}
+
+	n.children = append(n.children, nil)
+	copy(n.children[pos+1:], n.children[pos:])
+	n.children[pos] = child
+	n.wildChild = true
+
+	return nil
+}
+
+// scanWildcard return where name of the wildcard at path[i] ends, and where the wildcard ends.
+// wildcard name cannot contain ':' and '*', and stops when meet '/' or the end. the name may be
+// followed by a constraint, e.g. `:id<int>`
+func scanWildcard(path []byte, i int, fullPath []byte) (nameEnd int, end int, err error) {
+	end = i + 1
+	nameEnd = -1
+	for end < len(path) && path[end] != '/' {
+		switch path[end] {
+		case ':', '*':
+			return 0, 0, newPathError(fullPath, errWildcardInName, nil)
+		case '<':
+			nameEnd = end
+			if end = constraintEnd(path, end); end < 0 {
+				return 0, 0, newPathError(fullPath, errConstraintNotEnd, nil)
+			}
+		default:
+			end++
+		}
+	}
+	if nameEnd < 0 {
+		nameEnd = end
+	}
+
+	// check if the wildcard has a name
+	if nameEnd-i < 2 {
+		return 0, 0, newPathError(fullPath, errWildcardNameEmpty, nil)
+	}
+
+	return nameEnd, end, nil
+}
+
 // addRoute adds a node with given path, handle all the resource with it.
-// if it's a leaf, it should have a ring of `Status`.
-func (n *node) addRoute(path []byte, methods ...HTTPMethod) {
+// if it's a leaf, it should have a ring of `Status`. it return the node of path, or a
+// *pathError if path is bad, the tree may have been changed then, so it should be called on
+// a copy of the tree if the tree is in use.
+func (n *node) addRoute(path []byte, methods ...HTTPMethod) (*node, error) {
 	fullPath := path
 
 	/* tree is empty */
@@ -108,10 +210,10 @@ func (n *node) addRoute(path []byte, methods ...HTTPMethod) {
 		n.isLeaf = false
 		n.methods = NONE
 		n.status = nil
+		n.route = nil
 
 		// insert
-		n.insertChild(path, fullPath, methods...)
-		return
+		return n.insertChild(path, fullPath, methods...)
 	}
 
 	/* tree is not empty */
@@ -125,7 +227,8 @@ walk:
 			i++
 		}
 
-		// if max common prefix is shorter than n.path, split n
+		// if max common prefix is shorter than n.path, split n. wildcards are never split,
+		// because we walk into them only if path starts with the whole wildcard
 		if i < len(n.path) {
 			child := node{
 				path:      n.path[i:],
@@ -136,51 +239,68 @@ walk:
 				children:  n.children,
 				isLeaf:    n.isLeaf,
 				status:    n.status,
+				route:     n.route,
 			}
 
 			n.methods = NONE
 			n.isLeaf = false
 			n.status = nil
+			n.route = nil
 			n.children = []*node{&child}
 			n.indices = []byte{n.path[i]}
 			n.path = path[:i]
 			n.wildChild = false
 		}
 
-		// path is shorter or equal than n.path, so quit
+		// path is shorter or equal than n.path, so n is the node of path
 		if i == len(path) {
+			if !n.isLeaf {
+				n.isLeaf = true
+				n.status = StatusRing()
+				n.route = newRoute(fullPath)
+			}
 			n.setMethods(methods...)
-			return
+			return n, nil
 		}
 
 		// path is longer than n.path, so insert it!
 		path = path[i:]
+		c := path[0]
 
-		// only one wildChild is permit
-		if n.wildChild {
-			n = n.children[0]
+		switch n.nType {
+		case param:
+			// check for longer wildcard, e.g. :name and :names, param can be only followed by '/'
+			if c != '/' {
+				return nil, newPathError(fullPath, errWildcardConflict, n.path)
+			}
 
-			// check if wildcard matchs.
-			// for example, if n.path is `:name`, path should be `:name` or `:name/`
-			// only thses two cases are permit, panic if not
-			lenNode := len(n.path)
-			lenPath := len(path)
-			if lenPath >= lenNode && bytes.Equal(n.path, path[:lenNode]) &&
-				// Check for longer wildcard, e.g. :name and :names
-				(lenNode >= lenPath || path[lenNode] == '/') {
+			// e.g. path is `/jhon`, n.path is `:name`, and n.children is `/`
+			if len(n.children) == 1 {
+				n = n.children[0]
 				continue walk
-			} else {
-				log.Panicf("%s in %s conflict with node %s", path, fullPath, n.path)
-
 			}
+
+			child := &node{}
+			n.children = []*node{child}
+			return child.insertChild(path, fullPath, methods...)
+		case catchAll:
+			return nil, newPathError(fullPath, errCatchAllNotAtEnd, n.path)
 		}
 
-		c := path[0]
+		// walk into the same wildcard, or add a new one besides the existing children
+		if c == ':' || c == '*' {
+			_, end, err := scanWildcard(path, 0, fullPath)
+			if err != nil {
+				return nil, err
+			}
+			for _, child := range n.wildChildren() {
+				if bytes.Equal(child.path, path[:end]) {
+					n = child
+					continue walk
+				}
+			}
 
-		// check if n is slash after param, e.g. path is `/jhon`, n.path is `:name`, and n.children is `/`
-		if n.nType == param && c == '/' && len(n.children) == 1 {
-			n = n.children[0]
-			continue walk
+			return n.insertChild(path, fullPath, methods...)
 		}
 
 		// check if a child with next path bytes exists
@@ -193,103 +313,104 @@ walk:
 			}
 		}
 
-		// insert it!
-		if c != ':' && c != '*' {
-			n.indices = append(n.indices, c)
-			child := &node{}
-			n.children = append(n.children, child)
-			n = child
-		}
-		n.insertChild(path, fullPath, methods...)
+		// insert it! static children are in front of wildcards
+		child := &node{}
+		n.children = append(n.children, nil)
+		copy(n.children[len(n.indices)+1:], n.children[len(n.indices):])
+		n.children[len(n.indices)] = child
+		n.indices = append(n.indices, c)
+		return child.insertChild(path, fullPath, methods...)
 	}
 }
 
-func (n *node) insertChild(path []byte, fullPath []byte, methods ...HTTPMethod) {
+// insertChild insert path below n, and return the leaf. n is a new node which holds path, or
+// the parent of path if path starts with a wildcard
+func (n *node) insertChild(path []byte, fullPath []byte, methods ...HTTPMethod) (*node, error) {
 	var offset int // bytes in the path have already handled
-	var numParams uint8
 	var maxLen = len(path)
 
-	for i := 0; i < len(path); i++ {
-		if path[i] == ':' || path[i] == '*' {
-			numParams++
-		}
-	}
-
 	var i = 0
 	var c byte
-	for ; numParams > 0; numParams-- {
+	for {
 		// first step, find the first wildcard(beginning with ':' or '*') of the current path
-		for i = offset; i < len(path); i++ {
+		for i = offset; i < maxLen; i++ {
 			c = path[i]
 			if c == ':' || c == '*' {
 				break
 			}
 		}
-
-		// second step, find wildcard name, wildcard name cannot contain ':' and '*'
-		// stops when meet '/' or the end
-		end := i + 1
-		for end < maxLen && path[end] != '/' {
-			switch path[end] {
-			case ':', '*':
-				log.Panicf("wildcards ':' or '*' are not allowed in param names: %s in %s", path, fullPath)
-			default:
-				end++
-			}
-		}
-
-		// node whose type is param or catchAll are conflict, check it
-		if len(n.children) > 0 {
-			log.Panicf("wildcard route %s conflict with existing children in path %s", path[i:end], fullPath)
+		if i == maxLen {
+			break
 		}
 
-		// check if the wildcard has a name
-		if end-i < 2 {
-			log.Panicf("wildcards must be named with a non-empty name in path %s", fullPath)
+		// second step, find wildcard name and where it ends
+		nameEnd, end, err := scanWildcard(path, i, fullPath)
+		if err != nil {
+			return nil, err
 		}
 
 		if c == ':' { // param
+			child := &node{path: path[i:end], nType: param}
+			if nameEnd < end {
+				cons, err := newConstraint(path[i+1:nameEnd], path[nameEnd+1:end-1])
+				if err != nil {
+					return nil, newPathError(fullPath, errBadConstraint, []byte(err.Error()))
+				}
+				child.constraint = cons
+			}
+
 			// split path at the beginning of the wildcard
 			if i > 0 {
 				n.path = path[offset:i]
-				offset = i
 			}
+			offset = end
 
-			child := &node{nType: param}
-			n.children = []*node{child}
-			n.wildChild = true
+			if err := n.addWildChild(child, fullPath); err != nil {
+				return nil, err
+			}
 			n = child
 
-			// if the path doesn't end with the wildcard, then there will be another non-wildcard subpath
-			// starting with '/'
-			if end < maxLen {
-				n.path = path[offset:end]
-				offset = end
-
-				child := &node{}
-				n.children = []*node{child}
-				n = child
+			// the path ends with the wildcard
+			if end == maxLen {
+				n.setMethods(methods...)
+				n.isLeaf = true
+				n.status = StatusRing()
+				n.route = newRoute(fullPath)
+				return n, nil
 			}
+
+			// else there will be another non-wildcard subpath starting with '/'
+			child = &node{}
+			n.children = []*node{child}
+			n = child
 		} else { //catchAll
-			if end != maxLen || numParams > 1 {
-				log.Panicf("catchAll routers are only allowed once at the end of the path: %s", fullPath)
+			if end != maxLen {
+				return nil, newPathError(fullPath, errCatchAllNotAtEnd, nil)
+			}
+
+			if nameEnd < end {
+				return nil, newPathError(fullPath, errCatchAllConstraint, nil)
 			}
 
-			if path[i-1] != '/' {
-				log.Panicf("no / before catchAll in path %s", fullPath)
+			// the '/' before catchAll is in n.path if path starts with catchAll
+			if (i > 0 && path[i-1] != '/') || (i == 0 && (len(n.path) == 0 || n.path[len(n.path)-1] != '/')) {
+				return nil, newPathError(fullPath, errCatchAllNoSlash, nil)
 			}
 
 			// this node holding path 'xxx/'
-			n.path = path[offset:i]
-			n.wildChild = true
+			if i > 0 {
+				n.path = path[offset:i]
+			}
 
 			// child node holding the variable, '*xxxx'
-			child := &node{path: path[i:], nType: catchAll, isLeaf: true, status: StatusRing()}
+			child := &node{path: path[i:], nType: catchAll, isLeaf: true, status: StatusRing(), route: newRoute(fullPath)}
 			child.setMethods(methods...)
-			n.children = []*node{child}
+			if err := n.addWildChild(child, fullPath); err != nil {
+				return nil, err
+			}
 
 			// all done
-			return
+			return child, nil
 		}
 	}
 
@@ -298,95 +419,299 @@ func (n *node) insertChild(path []byte, fullPath []byte, methods ...HTTPMethod)
 	n.setMethods(methods...)
 	n.isLeaf = true
 	n.status = StatusRing()
+	n.route = newRoute(fullPath)
+
+	return n, nil
 }
 
-// byPath return a node with the given path
-func (n *node) byPath(path []byte) (nd *node, tsr bool, found bool) {
+// clone return a copy of the tree below n, leaves of the copy share status and route with
+// the ones of n, so stats are kept when the copy replaces n
+func (n *node) clone() *node {
+	c := &node{
+		path:       n.path,
+		nType:      n.nType,
+		methods:    n.methods,
+		wildChild:  n.wildChild,
+		indices:    append([]byte(nil), n.indices...),
+		isLeaf:     n.isLeaf,
+		status:     n.status,
+		route:      n.route,
+		constraint: n.constraint,
+	}
+	if len(n.children) > 0 {
+		c.children = make([]*node, len(n.children))
+		for i, child := range n.children {
+			c.children[i] = child.clone()
+		}
+	}
+
+	return c
+}
+
+// find return nodes from n to the node of path, path is a route, e.g. `/user/:name`, not a
+// request. it returns nil if the node does not exist
+func (n *node) find(path []byte) []*node {
+	nodes := []*node{}
+
 walk:
 	for {
-		if len(path) > len(n.path) {
-			if bytes.Equal(path[:len(n.path)], n.path) {
-				path = path[len(n.path):]
-				// if this node does not have a wildcard(param or catchAll) child, we can just look up
-				// the next child node and continue to walk down the tree
-				if !n.wildChild {
-					c := path[0]
-
-					for i := 0; i < len(n.indices); i++ {
-						if c == n.indices[i] {
-							n = n.children[i]
-							continue walk
-						}
+		if len(path) < len(n.path) || !bytes.Equal(path[:len(n.path)], n.path) {
+			return nil
+		}
+
+		nodes = append(nodes, n)
+		path = path[len(n.path):]
+		if len(path) == 0 {
+			return nodes
+		}
+
+		if n.nType == param {
+			if len(n.children) == 0 {
+				return nil
+			}
+			n = n.children[0]
+			continue walk
+		}
+
+		c := path[0]
+		if c == ':' || c == '*' {
+			end := 1
+			for end < len(path) && path[end] != '/' {
+				if path[end] == '<' {
+					if end = constraintEnd(path, end); end < 0 {
+						return nil
 					}
+					continue
+				}
+				end++
+			}
 
-					// nothing found
-					// we can recommend to redirect to the same URL without a trailing slash if a leaf
-					// exists for that path
-					tsr = (bytes.Equal(path, []byte("/")) && n.isLeaf)
-					return nil, tsr, false
+			for _, child := range n.wildChildren() {
+				if bytes.Equal(child.path, path[:end]) {
+					n = child
+					continue walk
 				}
+			}
+			return nil
+		}
 
-				// handle wildcard child
-				n = n.children[0]
-				switch n.nType {
-				case param:
-					end := 0
-					for end < len(path) && path[end] != '/' {
-						end++
-					}
+		for i := 0; i < len(n.indices); i++ {
+			if c == n.indices[i] {
+				n = n.children[i]
+				continue walk
+			}
+		}
+		return nil
+	}
+}
 
-					// we need to go deeper, because we've not visit all bytes in path
-					if end < len(path) {
-						if len(n.children) > 0 {
-							path = path[end:]
-							n = n.children[0]
-							continue walk
-						}
-
-						// oh, no, we can't go deeper
-						// if URL is `/user/:name/`, redirect it to `/user/:name`
-						tsr = (len(path) == end+1 && path[len(path)-1] == '/')
-						return nil, tsr, false
-					}
+// removeChild remove child from children of n
+func (n *node) removeChild(child *node) {
+	for i, c := range n.children {
+		if c != child {
+			continue
+		}
 
-					// else, n is the node we want if it's a leaf
-					if n.isLeaf {
-						return n, false, true
-					}
+		if i < len(n.indices) && n.nType != param {
+			n.indices = append(n.indices[:i], n.indices[i+1:]...)
+		}
+		n.children = append(n.children[:i], n.children[i+1:]...)
+		break
+	}
 
-					tsr = len(n.children) == 1 && n.children[0].isLeaf && bytes.Equal(n.children[0].path, []byte("/"))
-					return nil, tsr, false
-				case catchAll:
-					return n, false, true
-				default:
-					log.Panicf("invalid node type: %+v", n)
-				}
+	n.wildChild = n.nType != param && len(n.children) > len(n.indices)
+}
+
+// merge n with its child if n is not a leaf, and the only child is static, e.g. `/user` and
+// `s` are merged into `/users`
+func (n *node) merge() {
+	if n.isLeaf || n.nType == param || n.nType == catchAll || len(n.children) != 1 || len(n.indices) != 1 {
+		return
+	}
+
+	child := n.children[0]
+	path := make([]byte, 0, len(n.path)+len(child.path))
+	n.path = append(append(path, n.path...), child.path...)
+	n.methods = child.methods
+	n.wildChild = child.wildChild
+	n.indices = child.indices
+	n.children = child.children
+	n.isLeaf = child.isLeaf
+	n.status = child.status
+	n.route = child.route
+}
+
+// removeRoute remove the leaf of path from the tree below n, nodes which are not used any more
+// are removed, and compressed nodes are merged back. it return false if path is not a route.
+// it modifies the tree in place, so it should be called on a copy of the tree which is serving.
+func (n *node) removeRoute(path []byte) bool {
+	nodes := n.find(path)
+	if len(nodes) == 0 || !nodes[len(nodes)-1].isLeaf {
+		return false
+	}
+
+	leaf := nodes[len(nodes)-1]
+	leaf.isLeaf = false
+	leaf.methods = NONE
+	leaf.status = nil
+	leaf.route = nil
+
+	// remove nodes which have neither route nor children, from bottom to top
+	i := len(nodes) - 1
+	for ; i > 0; i-- {
+		nd := nodes[i]
+		if nd.isLeaf || len(nd.children) > 0 {
+			break
+		}
+		nodes[i-1].removeChild(nd)
+	}
+	nodes[i].merge()
+
+	// the tree is empty now
+	if !n.isLeaf && len(n.children) == 0 {
+		n.path = nil
+		n.indices = nil
+		n.wildChild = false
+	}
+
+	return true
+}
+
+// pathParam is a captured wildcard, both key and value refer to bytes which already exist, so
+// capturing does not allocate
+type pathParam struct {
+	key   []byte // name of wildcard, e.g. `name` of `:name`
+	value []byte
+}
+
+// params are wildcards captured by `lookup`, in the order they are in the path
+type params []pathParam
+
+// byName return value of the wildcard with the given name
+func (ps params) byName(name []byte) ([]byte, bool) {
+	for i := range ps {
+		if bytes.Equal(ps[i].key, name) {
+			return ps[i].value, true
+		}
+	}
+
+	return nil, false
+}
+
+var paramsPool = sync.Pool{New: func() interface{} { ps := make(params, 0, 8); return &ps }}
+
+func acquireParams() *params {
+	return paramsPool.Get().(*params)
+}
+
+func releaseParams(ps *params) {
+	*ps = (*ps)[:0]
+	paramsPool.Put(ps)
+}
+
+// paramName return name of the wildcard, e.g. `id` of `:id<int>`
+func (n *node) paramName() []byte {
+	if n.constraint != nil {
+		return n.constraint.name
+	}
+	return n.path[1:]
+}
+
+// byPath return a node with the given path
+func (n *node) byPath(path []byte) (nd *node, tsr bool, found bool) {
+	return n.lookup(path, nil)
+}
+
+// lookup return a node with the given path, and append values of wildcards in path to ps
+// if it's not nil. if nothing found, tsr is true if the path with(or without) a trailing slash
+// can be found
+func (n *node) lookup(path []byte, ps *params) (nd *node, tsr bool, found bool) {
+	if nd = n.match(path, ps); nd != nil {
+		return nd, false, true
+	}
+
+	// e.g. URL is `/user/jhon/card`, but request `/user/jhon/card/`
+	if len(path) > 1 && path[len(path)-1] == '/' {
+		return nil, n.match(path[:len(path)-1], nil) != nil, false
+	}
+
+	// e.g. URL is `/user/jhon/card/`, but request `/user/jhon/card`
+	bufp := acquireBuf()
+	buf := append(append(*bufp, path...), '/')
+	tsr = n.match(buf, nil) != nil
+	*bufp = buf
+	releaseBuf(bufp)
+
+	return nil, tsr, false
+}
+
+// match return the leaf of path below n(static or root), static children take precedence over
+// params, and params over catchAll. if a child fails to match the rest of path, the next one
+// is tried, and wildcards captured by it are dropped
+func (n *node) match(path []byte, ps *params) *node {
+	if len(path) < len(n.path) || !bytes.Equal(path[:len(n.path)], n.path) {
+		return nil
+	}
+
+	path = path[len(n.path):]
+	if len(path) == 0 {
+		if n.isLeaf {
+			return n
+		}
+		return nil
+	}
+
+	c := path[0]
+	for i := 0; i < len(n.indices); i++ {
+		if c == n.indices[i] {
+			if nd := n.children[i].match(path, ps); nd != nil {
+				return nd
 			}
-		} else if bytes.Equal(path, n.path) {
-			if n.isLeaf {
-				return n, false, true
+			break
+		}
+	}
+
+	// handle wildcard children
+	for _, child := range n.wildChildren() {
+		switch child.nType {
+		case param:
+			end := 0
+			for end < len(path) && path[end] != '/' {
+				end++
+			}
+			if end == 0 || (child.constraint != nil && !child.constraint.match(path[:end])) {
+				continue
 			}
 
-			// it seems that the case in below(comment) will never hapeen...
-			//if path == "/" && n.wildChild && n.nType != root {
-			//return nil, true, false
-			//}
+			captured := 0
+			if ps != nil {
+				captured = len(*ps)
+				*ps = append(*ps, pathParam{child.paramName(), path[:end]})
+			}
 
-			// nothing found, check if a child with this path + a trailing slash exists
-			for i := 0; i < len(n.indices); i++ {
-				if n.indices[i] == '/' {
-					n = n.children[i]
-					tsr = len(n.path) == 1 && n.isLeaf
+			if end == len(path) && child.isLeaf {
+				return child
+			}
+			// we need to go deeper, because we've not visit all bytes in path
+			if end < len(path) && len(child.children) > 0 {
+				if nd := child.children[0].match(path[end:], ps); nd != nil {
+					return nd
 				}
+			}
 
-				return nil, tsr, false
+			// backtrack, drop the captured
+			if ps != nil {
+				*ps = (*ps)[:captured]
+			}
+		case catchAll:
+			if ps != nil {
+				*ps = append(*ps, pathParam{child.paramName(), path})
 			}
+			return child
+		default:
+			log.Panicf("invalid node type: %+v", child)
 		}
-
-		// nothing found, e.g. URL is `/user/jhon/card/`, but request `/user/jhon/card`
-		tsr = (bytes.Equal(path, []byte("/"))) ||
-			(len(n.path) == len(path)+1 && n.path[len(path)] == '/' &&
-				bytes.Equal(path, n.path[:len(n.path)-1]) && n.isLeaf)
-		return nil, tsr, false
 	}
+
+	return nil
 }
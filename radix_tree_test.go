@@ -1,6 +1,7 @@
 package main
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -123,29 +124,48 @@ func shouldPanic() {
 	}
 }
 
-func TestInsertBadParamDualWildchard(t *testing.T) {
-	defer shouldPanic()
+// shouldBePathError check err is a *pathError of path because of reason
+func shouldBePathError(t *testing.T, err error, path string, reason error) {
+	t.Helper()
+
+	e, ok := err.(*pathError)
+	if !ok || e.path != path || e.err != reason {
+		t.Errorf("%s should be bad because %s, but got: %v", path, reason, err)
+	}
+}
 
+func TestInsertBadParamDualWildchard(t *testing.T) {
 	n := &node{}
 
-	n.insertChild([]byte("/:name:this"), []byte("/user/:name:this/there"))
+	_, err := n.insertChild([]byte("/:name:this"), []byte("/user/:name:this/there"))
+	shouldBePathError(t, err, "/user/:name:this/there", errWildcardInName)
 }
 
 func TestInsertBadParamNoParamName(t *testing.T) {
-	defer shouldPanic()
-
 	n := &node{}
 
-	n.insertChild([]byte("/:"), []byte("/user/:/there"))
+	_, err := n.insertChild([]byte("/:"), []byte("/user/:/there"))
+	shouldBePathError(t, err, "/user/:/there", errWildcardNameEmpty)
 }
 
 func TestInsertBadParamConflict(t *testing.T) {
-	defer shouldPanic()
-
 	n := &node{}
 
 	n.insertChild([]byte("/:name"), []byte("/user/:name/there"))
-	n.insertChild([]byte("/:name"), []byte("/user/:name/there"))
+	_, err := n.insertChild([]byte("/:name"), []byte("/user/:name/there"))
+	shouldBePathError(t, err, "/user/:name/there", errWildcardConflict)
+}
+
+func TestPathErrorMessage(t *testing.T) {
+	err := newPathError([]byte("/user/:id"), errWildcardConflict, []byte(":name"))
+	if msg := err.Error(); msg != "bad path /user/:id: "+errWildcardConflict.Error()+": :name" {
+		t.Errorf("bad message: %s", msg)
+	}
+
+	err = newPathError([]byte("/user/:"), errWildcardNameEmpty, nil)
+	if msg := err.Error(); msg != "bad path /user/:: "+errWildcardNameEmpty.Error() {
+		t.Errorf("bad message: %s", msg)
+	}
 }
 
 func TestInsertDualParam(t *testing.T) {
@@ -196,17 +216,15 @@ func TestInsertChildCatchAll(t *testing.T) {
 }
 
 func TestInsertCatchAllMultiTimes(t *testing.T) {
-	defer shouldPanic()
-
 	n := &node{}
-	n.insertChild([]byte("/*name/:haha"), []byte("/*name/:haha"))
+	_, err := n.insertChild([]byte("/*name/:haha"), []byte("/*name/:haha"))
+	shouldBePathError(t, err, "/*name/:haha", errCatchAllNotAtEnd)
 }
 
 func TestInsertCatchAllNoSlash(t *testing.T) {
-	defer shouldPanic()
-
 	n := &node{}
-	n.insertChild([]byte("/user*name"), []byte("/user*name"))
+	_, err := n.insertChild([]byte("/user*name"), []byte("/user*name"))
+	shouldBePathError(t, err, "/user*name", errCatchAllNoSlash)
 }
 
 func TestAddRoute(t *testing.T) {
@@ -302,12 +320,67 @@ func TestAddRouteDualWildChild(t *testing.T) {
 	)
 }
 
-func TestAddRouteWildParamConflict(t *testing.T) {
-	defer shouldPanic()
-
+func TestAddRouteWildParamAndCatchAll(t *testing.T) {
 	n := &node{}
 	n.addRoute([]byte("/user/:name/hello/world"))
 	n.addRoute([]byte("/user/*whoever"))
+	n.addRoute([]byte("/user/new"))
+	n.addRoute([]byte("/user/:id<int>"))
+
+	checkNodeValid(
+		t, n,
+		nodeExpceted{"/user/", root, NONE, true, false, 4, false, true},
+	)
+
+	// static children are in front of wildcards, and param with constraint is in front of the others
+	for i, e := range []struct {
+		path  string
+		nType nodeType
+	}{{"new", static}, {":id<int>", param}, {":name", param}, {"*whoever", catchAll}} {
+		if c := n.children[i]; string(c.path) != e.path || c.nType != e.nType {
+			t.Errorf("the %dth child should be %s, but got: %+v", i, e.path, c)
+		}
+	}
+}
+
+func TestAddRouteWildConflict(t *testing.T) {
+	for _, e := range []struct {
+		paths  []string
+		reason error
+	}{
+		{[]string{"/user/:name", "/user/:id"}, errWildcardConflict},
+		{[]string{"/user/*name", "/user/*path"}, errWildcardConflict},
+		{[]string{"/user/:name", "/user/:names"}, errWildcardConflict},
+		{[]string{"/user/*name", "/user/*name/card"}, errCatchAllNotAtEnd},
+	} {
+		n := &node{}
+		if _, err := n.addRoute([]byte(e.paths[0])); err != nil {
+			t.Fatalf("%s should be added but got: %s", e.paths[0], err)
+		}
+		_, err := n.addRoute([]byte(e.paths[1]))
+		shouldBePathError(t, err, e.paths[1], e.reason)
+	}
+}
+
+func TestAddRoutePrefix(t *testing.T) {
+	n := &node{}
+	n.addRoute([]byte("/api/*path"), GET)
+	n.addRoute([]byte("/user/:name/hello"), GET)
+	leaf, _ := n.addRoute([]byte("/"), POST)
+	name, _ := n.addRoute([]byte("/user/:name"), GET)
+
+	if leaf != n || !n.isLeaf || n.status == nil || n.route == nil || !n.hasMethod(POST) {
+		t.Errorf("n should become a leaf, but got: %+v", n)
+	}
+	if name.nType != param || !name.isLeaf || name.route.path != "/user/:name" {
+		t.Errorf("param should become a leaf, but got: %+v", name)
+	}
+
+	for _, path := range []string{"/", "/api/users", "/user/jhon", "/user/jhon/hello"} {
+		if _, _, found := n.byPath([]byte(path)); !found {
+			t.Errorf("%s should be found", path)
+		}
+	}
 }
 
 func TestAddRouteMultiIndices(t *testing.T) {
@@ -429,6 +502,203 @@ func TestByPathBadNode(t *testing.T) {
 	n.byPath([]byte("/user/jhon"))
 }
 
+func TestLookupParams(t *testing.T) {
+	n := &node{}
+	n.addRoute([]byte("/user/:name/card/*path"), GET)
+	n.addRoute([]byte("/order/:id"), GET)
+
+	ps := acquireParams()
+	defer releaseParams(ps)
+	if _, _, found := n.lookup([]byte("/user/jhon/card/a/b"), ps); !found || len(*ps) != 2 {
+		t.Fatalf("should found with 2 params but got: %t, %d", found, len(*ps))
+	}
+	if name, ok := ps.byName([]byte("name")); !ok || string(name) != "jhon" {
+		t.Errorf("name should be jhon but got: %s", name)
+	}
+	if path, ok := ps.byName([]byte("path")); !ok || string(path) != "a/b" {
+		t.Errorf("path should be a/b but got: %s", path)
+	}
+	if _, ok := ps.byName([]byte("id")); ok {
+		t.Errorf("id should not be captured")
+	}
+}
+
+func TestInsertBadConstraint(t *testing.T) {
+	for _, e := range []struct {
+		path   string
+		reason error
+	}{
+		{"/user/:id<int", errConstraintNotEnd},
+		{"/user/:id<int>s", errConstraintNotEnd},
+		{"/user/:<int>", errWildcardNameEmpty},
+		{"/user/:id<[a-z>", errBadConstraint},
+		{"/share/*path<int>", errCatchAllConstraint},
+	} {
+		n := &node{}
+		_, err := n.addRoute([]byte(e.path), GET)
+		shouldBePathError(t, err, e.path, e.reason)
+	}
+}
+
+func TestLookupConstraint(t *testing.T) {
+	n := &node{}
+	n.addRoute([]byte("/order/:id<int>"), GET)
+	n.addRoute([]byte(`/file/:name<[a-z]+\.png>/:size<[0-9]*x[0-9]*>`), GET)
+
+	expects := []struct {
+		path  string
+		found bool
+	}{
+		{"/order/42", true},
+		{"/order/jhon", false},
+		{"/file/cat.png/10x10", true},
+		{"/file/cat.png/x", true},
+		{"/file/cat.jpg/10x10", false},
+		{"/file/cat.png/10", false},
+	}
+	for _, e := range expects {
+		ps := acquireParams()
+		_, _, found := n.lookup([]byte(e.path), ps)
+		if found != e.found {
+			t.Errorf("%s should be found? %t, but got: %t", e.path, e.found, found)
+		}
+		releaseParams(ps)
+	}
+
+	ps := acquireParams()
+	defer releaseParams(ps)
+	n.lookup([]byte("/file/cat.png/10x10"), ps)
+	if size, ok := ps.byName([]byte("size")); !ok || string(size) != "10x10" {
+		t.Errorf("size should be 10x10, but got: %s", size)
+	}
+}
+
+func TestLookupPriority(t *testing.T) {
+	n := &node{}
+	n.addRoute([]byte("/user/new"), GET)
+	n.addRoute([]byte("/user/:id<int>/cards"), GET)
+	n.addRoute([]byte("/user/:name/cards/:card"), GET)
+	n.addRoute([]byte("/user/:name"), GET)
+	n.addRoute([]byte("/user/*path"), GET)
+	n.addRoute([]byte("/user/news/today"), GET)
+
+	expects := []struct {
+		path   string
+		route  string
+		params string
+	}{
+		{"/user/new", "/user/new", ""},
+		{"/user/news", "/user/:name", "name=news"},
+		{"/user/news/today", "/user/news/today", ""},
+		{"/user/news/yesterday", "/user/*path", "path=news/yesterday"},
+		{"/user/42/cards", "/user/:id<int>/cards", "id=42"},
+		{"/user/42/cards/1", "/user/:name/cards/:card", "name=42,card=1"},
+		{"/user/jhon/cards", "/user/*path", "path=jhon/cards"},
+		{"/user/jhon/cards/1/2", "/user/*path", "path=jhon/cards/1/2"},
+	}
+	for _, e := range expects {
+		ps := acquireParams()
+		nd, _, found := n.lookup([]byte(e.path), ps)
+		if !found || nd.route.path != e.route {
+			t.Errorf("%s should match %s, but got: %+v", e.path, e.route, nd)
+		}
+
+		captured := []string{}
+		for _, p := range *ps {
+			captured = append(captured, string(p.key)+"="+string(p.value))
+		}
+		if params := strings.Join(captured, ","); params != e.params {
+			t.Errorf("params of %s should be %s, but got: %s", e.path, e.params, params)
+		}
+		releaseParams(ps)
+	}
+
+	// trailing slash redirect works with backtracking
+	n = &node{}
+	n.addRoute([]byte("/user/new"), GET)
+	n.addRoute([]byte("/user/:id<int>/cards"), GET)
+	n.addRoute([]byte("/user/:name/"), GET)
+	for _, path := range []string{"/user/42/cards/", "/user/jhon"} {
+		if _, tsr, found := n.byPath([]byte(path)); found || !tsr {
+			t.Errorf("%s should be redirected, but got: %t, %t", path, tsr, found)
+		}
+	}
+}
+
+func TestRemoveRouteFromTree(t *testing.T) {
+	n := &node{}
+	n.addRoute([]byte("/user/hello"), GET)
+	n.addRoute([]byte("/user/world"), GET)
+	n.addRoute([]byte("/user/:name/cards"), GET)
+	n.addRoute([]byte("/user/*path"), GET)
+
+	if n.removeRoute([]byte("/user")) || n.removeRoute([]byte("/user/:id/cards")) || n.removeRoute([]byte("/user/:name")) {
+		t.Errorf("should not remove route which does not exist")
+	}
+
+	world, _, _ := n.byPath([]byte("/user/world"))
+	if !n.removeRoute([]byte("/user/hello")) || !n.removeRoute([]byte("/user/:name/cards")) || !n.removeRoute([]byte("/user/*path")) {
+		t.Fatalf("should remove route")
+	}
+
+	// compressed nodes are merged back
+	checkNodeValid(
+		t, n,
+		nodeExpceted{"/user/world", root, GET, false, true, 0, true, false},
+	)
+	if n.status != world.status || n.route != world.route {
+		t.Errorf("route which is not removed should keep its stats")
+	}
+
+	if !n.removeRoute([]byte("/user/world")) || len(n.path) != 0 {
+		t.Errorf("tree should be empty, but got: %+v", n)
+	}
+	n.addRoute([]byte("/users"), GET)
+	if _, _, found := n.byPath([]byte("/users")); !found {
+		t.Errorf("route should be added to empty tree")
+	}
+}
+
+func TestRemoveRouteKeepChildren(t *testing.T) {
+	n := &node{}
+	n.addRoute([]byte("/user"), GET)
+	n.addRoute([]byte("/user/:name"), GET)
+	n.addRoute([]byte("/user/:name/cards"), GET)
+
+	if !n.removeRoute([]byte("/user/:name")) || !n.removeRoute([]byte("/user")) {
+		t.Fatalf("should remove route")
+	}
+
+	for path, found := range map[string]bool{"/user": false, "/user/jhon": false, "/user/jhon/cards": true} {
+		if _, _, f := n.byPath([]byte(path)); f != found {
+			t.Errorf("%s should be found? %t, but got: %t", path, found, f)
+		}
+	}
+}
+
+func TestCloneTree(t *testing.T) {
+	n := &node{}
+	n.addRoute([]byte("/user/:name"), GET)
+	n.addRoute([]byte("/users"), GET)
+
+	c := n.clone()
+	c.addRoute([]byte("/usera"), GET)
+	c.removeRoute([]byte("/user/:name"))
+
+	if _, _, found := n.byPath([]byte("/user/jhon")); !found {
+		t.Errorf("original tree should not be changed")
+	}
+	if _, _, found := n.byPath([]byte("/usera")); found {
+		t.Errorf("original tree should not be changed")
+	}
+
+	a, _, _ := n.byPath([]byte("/users"))
+	b, _, _ := c.byPath([]byte("/users"))
+	if a == b || a.status != b.status || a.route != b.route {
+		t.Errorf("leaves should share status and route")
+	}
+}
+
 // benchmark
 func BenchmarkByPath(b *testing.B) {
 	n := &node{}
@@ -465,3 +735,16 @@ func BenchmarkByPathCatchall(b *testing.B) {
 		n.byPath([]byte("/user/hello/world/this/is/so/long"))
 	}
 }
+
+func BenchmarkLookupParams(b *testing.B) {
+	n := &node{}
+	n.addRoute([]byte("/user/:name/card/*path"))
+	path := []byte("/user/jhon/card/this/is/so/long")
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		ps := acquireParams()
+		n.lookup(path, ps)
+		releaseParams(ps)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/valyala/fasthttp"
+)
+
+/*
+path rewriting, a route may rewrite path of requests before they're proxied. it either strips
+and adds a prefix, or renders a template whose `:name` and `*name` are replaced with the
+wildcards captured from the path, e.g. route `/v1/users/:id` with template
+`/internal/user?id=:id` proxies `/v1/users/42?v=2` as `/internal/user?v=2&id=42`. the original
+path is kept in header `X-Original-Path`, and stats are still counted by the route.
+*/
+
+const originalPathHeader = "X-Original-Path"
+
+var (
+	errRewriteConflict      = errors.New("rewrite of route should not be used with strip_prefix or add_prefix")
+	errBadRewritePath       = errors.New("rewrite, strip_prefix and add_prefix of route should start with /")
+	errRewriteParamNotFound = errors.New("params in rewrite should be wildcards of the route path")
+)
+
+// rewritePart is either a literal, or a wildcard whose value replaces it
+type rewritePart struct {
+	literal []byte
+	param   []byte // name of wildcard, nil if it's a literal
+	inQuery bool   // the value should be escaped if it's in query
+}
+
+type rewrite struct {
+	strip []byte
+	add   []byte
+	parts []rewritePart // nil if it's not a template
+}
+
+// wildcards return names of wildcards in path, e.g. `name` and `path` of `/user/:name<[a-z]+>/*path`
+func wildcards(path string) []string {
+	names := []string{}
+	for i := 0; i < len(path); i++ {
+		if path[i] != ':' && path[i] != '*' {
+			continue
+		}
+
+		end := i + 1
+		for end < len(path) && path[end] != '/' && path[end] != '<' {
+			end++
+		}
+		names = append(names, path[i+1:end])
+
+		// skip the constraint, e.g. `<int>` of `:id<int>`
+		if end < len(path) && path[end] == '<' {
+			if end = constraintEnd([]byte(path), end); end < 0 {
+				break
+			}
+		}
+		i = end
+	}
+
+	return names
+}
+
+func isParamNameByte(c byte) bool {
+	return c == '_' || ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// newRewrite return a rewrite for route of path, nil if nothing should be rewritten
+func newRewrite(path, strip, add, template string) (*rewrite, error) {
+	if template == "" {
+		if strip == "" && add == "" {
+			return nil, nil
+		}
+		if (strip != "" && strip[0] != '/') || (add != "" && add[0] != '/') {
+			return nil, errBadRewritePath
+		}
+		return &rewrite{strip: []byte(strip), add: []byte(add)}, nil
+	}
+
+	if strip != "" || add != "" {
+		return nil, errRewriteConflict
+	}
+	if template[0] != '/' {
+		return nil, errBadRewritePath
+	}
+
+	names := map[string]bool{}
+	for _, name := range wildcards(path) {
+		names[name] = true
+	}
+
+	rw := &rewrite{}
+	inQuery := false
+	start := 0
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+		if c == '?' {
+			inQuery = true
+		}
+		if c != ':' && c != '*' {
+			continue
+		}
+
+		end := i + 1
+		for end < len(template) && isParamNameByte(template[end]) {
+			end++
+		}
+		if end == i+1 {
+			// not followed by a name, e.g. `a:b` is `a` + `:b`, but `a:` is just a literal
+			continue
+		}
+
+		name := template[i+1 : end]
+		if !names[name] {
+			return nil, errRewriteParamNotFound
+		}
+		if start < i {
+			rw.parts = append(rw.parts, rewritePart{literal: []byte(template[start:i])})
+		}
+		rw.parts = append(rw.parts, rewritePart{param: []byte(name), inQuery: inQuery})
+		start = end
+		i = end - 1
+	}
+	if start < len(template) {
+		rw.parts = append(rw.parts, rewritePart{literal: []byte(template[start:])})
+	}
+
+	return rw, nil
+}
+
+// apply rewrite path of the request, ps are wildcards captured from its path
+func (rw *rewrite) apply(ctx *fasthttp.RequestCtx, ps params) {
+	uri := ctx.URI()
+	// the original path which client sent, decoded path may contain bytes not allowed in header
+	ctx.Request.Header.SetBytesV(originalPathHeader, uri.PathOriginal())
+
+	bufp := acquireBuf()
+	buf := *bufp
+	defer func() {
+		*bufp = buf
+		releaseBuf(bufp)
+	}()
+
+	if rw.parts == nil {
+		path := uri.Path()
+		if bytes.HasPrefix(path, rw.strip) {
+			path = path[len(rw.strip):]
+		}
+		buf = append(buf, rw.add...)
+		if len(path) == 0 || path[0] != '/' {
+			if len(buf) == 0 || buf[len(buf)-1] != '/' {
+				buf = append(buf, '/')
+			}
+		} else if len(buf) > 0 && buf[len(buf)-1] == '/' {
+			path = path[1:]
+		}
+		buf = append(buf, path...)
+
+		uri.SetPathBytes(buf)
+		return
+	}
+
+	for _, part := range rw.parts {
+		if part.param == nil {
+			buf = append(buf, part.literal...)
+			continue
+		}
+
+		value, _ := ps.byName(part.param)
+		if part.inQuery {
+			buf = fasthttp.AppendQuotedArg(buf, value)
+		} else {
+			buf = append(buf, value...)
+		}
+	}
+
+	// query of template is merged into the query of request
+	query := bytes.IndexByte(buf, '?')
+	if query < 0 {
+		uri.SetPathBytes(buf)
+		return
+	}
+
+	args := fasthttp.AcquireArgs()
+	args.ParseBytes(buf[query+1:])
+	queryArgs := uri.QueryArgs()
+	args.VisitAll(func(key, value []byte) {
+		queryArgs.SetBytesKV(key, value)
+	})
+	fasthttp.ReleaseArgs(args)
+
+	uri.SetPathBytes(buf[:query])
+}
+
+// rewritePath rewrite path of the request if the route should
+func (r *route) rewritePath(ctx *fasthttp.RequestCtx, ps params) {
+	if r == nil || r.rewrite == nil {
+		return
+	}
+	r.rewrite.apply(ctx, ps)
+}
+
+// SetRouteRewrite let route of path rewrite path of requests before they're proxied, nil means
+// never rewrite
+func (a *Application) SetRouteRewrite(path string, rw *rewrite) error {
+	route, exist := a.routes[path]
+	if !exist {
+		return errRuleRouteNotFound
+	}
+
+	route.rewrite = rw
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestNewRewrite(t *testing.T) {
+	expects := []struct {
+		path, strip, add, template string
+		err                        error
+	}{
+		{"/api/*path", "/api", "/internal", "", nil},
+		{"/v1/users/:id", "", "", "/internal/user?id=:id", nil},
+		{"/v1/users/:id/*rest", "", "", "/users/:id/*rest", nil},
+		{"/v1/users/:id", "", "", "/users/:name", errRewriteParamNotFound},
+		{"/v1/users/:id<int>/*rest", "", "", "/users/:id/*rest", nil},
+		{"/api/*path", "/api", "", "/internal/*path", errRewriteConflict},
+		{"/api/*path", "api", "", "", errBadRewritePath},
+		{"/api/*path", "", "", "internal/*path", errBadRewritePath},
+	}
+	for i, e := range expects {
+		if _, err := newRewrite(e.path, e.strip, e.add, e.template); err != e.err {
+			t.Errorf("the %dth rewrite should return %v but got: %v", i, e.err, err)
+		}
+	}
+
+	if rw, err := newRewrite("/api/*path", "", "", ""); rw != nil || err != nil {
+		t.Errorf("should return nothing but got: %+v, %s", rw, err)
+	}
+}
+
+func TestRewriteApply(t *testing.T) {
+	expects := []struct {
+		route, strip, add, template string
+		uri                         string
+		rewritten                   string
+	}{
+		{"/api/*path", "/api", "", "", "/api/users?v=2", "/users?v=2"},
+		{"/api/*path", "/api/", "/internal/", "", "/api/users", "/internal/users"},
+		{"/api/*path", "", "/internal", "", "/api/users", "/internal/api/users"},
+		{"/api/*path", "/api/users", "", "", "/api/users", "/"},
+		{"/v1/users/:id", "", "", "/internal/user?id=:id", "/v1/users/42?v=2", "/internal/user?v=2&id=42"},
+		{"/v1/users/:id", "", "", "/internal/user?id=:id", "/v1/users/a%26b?id=1", "/internal/user?id=a%26b"},
+		{"/v1/users/:id/*rest", "", "", "/users/:id/*rest.json", "/v1/users/42/cards/1", "/users/42/cards/1.json"},
+	}
+	for _, e := range expects {
+		root := &node{}
+		root.addRoute([]byte(e.route), GET)
+		rw, err := newRewrite(e.route, e.strip, e.add, e.template)
+		if err != nil {
+			t.Fatalf("should not return error but got: %s", err)
+		}
+
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("http://example.com" + e.uri)
+		ps := acquireParams()
+		if _, _, found := root.lookup(ctx.Path(), ps); !found {
+			t.Fatalf("%s should be found in %s", e.uri, e.route)
+		}
+		rw.apply(ctx, *ps)
+		releaseParams(ps)
+
+		if uri := string(ctx.URI().RequestURI()); uri != e.rewritten {
+			t.Errorf("%s should be rewritten to %s, but got: %s", e.uri, e.rewritten, uri)
+		}
+		path := strings.SplitN(e.uri, "?", 2)[0]
+		if original := string(ctx.Request.Header.Peek(originalPathHeader)); original != path {
+			t.Errorf("original path %s should be kept, but got: %s", path, original)
+		}
+	}
+}
+
+func TestRouteRewrite(t *testing.T) {
+	backend, stop := inmemoryBackend(t, func(ctx *fasthttp.RequestCtx) {
+		ctx.Write(ctx.RequestURI())
+		ctx.WriteString(" ")
+		ctx.Write(ctx.Request.Header.Peek(originalPathHeader))
+	})
+	defer stop()
+
+	a := NewApp(NewRR(backend), true)
+	a.AddRoute("/v1/users/:id", "GET")
+	a.AddRoute("/static/*path", "GET")
+
+	rw, _ := newRewrite("/v1/users/:id", "", "", "/internal/user?id=:id")
+	if err := a.SetRouteRewrite("/what", rw); err != errRuleRouteNotFound {
+		t.Errorf("should return %s but got: %s", errRuleRouteNotFound, err)
+	}
+	a.SetRouteRewrite("/v1/users/:id", rw)
+
+	for _, e := range []struct {
+		uri  string
+		body string
+	}{
+		{"/v1/users/42", "/internal/user?id=42 /v1/users/42"},
+		{"/static/a.css", "/static/a.css "}, // not rewritten
+	} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("http://example.com" + e.uri)
+		a.ServeHTTP(ctx)
+		if body := string(ctx.Response.Body()); body != e.body {
+			t.Errorf("response of %s should be %s, but got: %s", e.uri, e.body, body)
+		}
+	}
+
+	// stats are still counted by the route
+	n, _, _ := a.tree().byPath([]byte("/v1/users/42"))
+	if requests, _, _, _, _ := n.query(); requests != 1 {
+		t.Errorf("route should have 1 request, but got: %d", requests)
+	}
+}
+
+func TestRouteRewriteRules(t *testing.T) {
+	stable, stopStable := inmemoryBackend(t, func(ctx *fasthttp.RequestCtx) { ctx.WriteString("stable") })
+	defer stopStable()
+	v2, stopV2 := inmemoryBackend(t, func(ctx *fasthttp.RequestCtx) { ctx.WriteString("v2") })
+	defer stopV2()
+
+	a := NewApp(NewRR(stable), true)
+	a.AddRoute("/v1/users/:id", "GET")
+	a.setGroups([]*backendGroup{newBackendGroup("v2", NewRR(v2))}, []int{0}, "", "")
+	a.AddRule(ruleConfig{Path: "/v1/users/:id", Match: []matcherConfig{{Source: matchQuery, Name: "v", Value: "2"}}, Group: "v2"})
+	// v of the query is overwritten by the rewrite
+	rw, _ := newRewrite("/v1/users/:id", "", "", "/internal/user?id=:id&v=1")
+	a.SetRouteRewrite("/v1/users/:id", rw)
+
+	for uri, body := range map[string]string{"/v1/users/42?v=2": "v2", "/v1/users/42": "stable"} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("http://example.com" + uri)
+		a.ServeHTTP(ctx)
+		if b := string(ctx.Response.Body()); b != body {
+			t.Errorf("%s should be proxied to %s, but got: %s", uri, body, b)
+		}
+	}
+}
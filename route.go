@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+/*
+routing rules, besides method and path, a route may have rules which match request headers,
+query arguments and cookies, every rule points at a group of backends. rules of a route are
+checked in the order they are configured, the first matched one wins. if none of them matches,
+the request is pinned by header or cookie, or split by percentage as usual.
+*/
+
+const (
+	matchHeader = "header"
+	matchQuery  = "query"
+	matchCookie = "cookie"
+
+	matchExact  = "exact"
+	matchPrefix = "prefix"
+	matchRegex  = "regex"
+)
+
+var (
+	errRuleRouteNotFound = errors.New("path of rule should be one of paths of the app")
+	errRuleGroupNotFound = errors.New("group of rule not found")
+	errRuleMatchEmpty    = errors.New("rule should match at least one header, query or cookie")
+	errBadMatchSource    = errors.New("bad match source, only header, query, cookie are support now")
+	errMatchNameEmpty    = errors.New("name of header, query or cookie to match is required")
+	errBadMatchType      = errors.New("bad match type, only exact, prefix, regex are support now")
+	errRouteExists       = errors.New("path of route should be unique")
+	errBadRouteTimeout   = errors.New("timeout of route should not be negative")
+)
+
+type matcherConfig struct {
+	Source string `json:"source"` // header, query or cookie
+	Name   string `json:"name"`   // e.g. X-Api-Version
+	Type   string `json:"type"`   // exact, prefix or regex, exact by default
+	Value  string `json:"value"`  // e.g. 2
+}
+
+type ruleConfig struct {
+	Path  string          `json:"path"`  // e.g. /api/*path, the rule applies to every route if it's empty
+	Match []matcherConfig `json:"match"` // all of them should match
+	Group string          `json:"group"` // e.g. v2, or stable for backends of the app itself
+}
+
+func checkRuleConfigs(rules []ruleConfig, paths []string, groups []groupConfig) error {
+	for _, r := range rules {
+		found := r.Path == ""
+		for _, path := range paths {
+			found = found || path == r.Path
+		}
+		if !found {
+			return errRuleRouteNotFound
+		}
+
+		found = r.Group == stableGroup
+		for _, g := range groups {
+			found = found || g.Name == r.Group
+		}
+		if !found {
+			return errRuleGroupNotFound
+		}
+
+		if len(r.Match) == 0 {
+			return errRuleMatchEmpty
+		}
+		for i := range r.Match {
+			if _, err := newMatcher(&r.Match[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// routeConfig let a route have its own backends, load balance method and timeout
+type routeConfig struct {
+	Path              string   `json:"path"` // e.g. /api/*path, should be one of paths of the app
+	Backends          []string `json:"backends,omitempty"`
+	Weights           []int    `json:"weights,omitempty"`
+	LoadBalanceMethod string   `json:"load_balance_method,omitempty"` // load balance method of the app by default
+	Timeout           int64    `json:"timeout,omitempty"`             // in milliseconds, 0 means no timeout
+
+	// path is rewritten before proxying, by stripping and adding prefix, or by template
+	StripPrefix string `json:"strip_prefix,omitempty"` // e.g. /api
+	AddPrefix   string `json:"add_prefix,omitempty"`   // e.g. /internal
+	Rewrite     string `json:"rewrite,omitempty"`      // e.g. /internal/user?id=:id for /v1/users/:id
+}
+
+func checkRouteConfigs(routes []routeConfig, paths []string, loadBalanceMethod string) error {
+	seen := map[string]bool{}
+	for i := range routes {
+		r := &routes[i]
+
+		found := false
+		for _, path := range paths {
+			found = found || path == r.Path
+		}
+		if !found {
+			return errRuleRouteNotFound
+		}
+		if seen[r.Path] {
+			return errRouteExists
+		}
+		seen[r.Path] = true
+
+		if len(r.Backends) != len(r.Weights) {
+			return errBackendWeightNotMatch
+		}
+		if r.Timeout < 0 {
+			return errBadRouteTimeout
+		}
+		if _, err := newRewrite(r.Path, r.StripPrefix, r.AddPrefix, r.Rewrite); err != nil {
+			return err
+		}
+
+		switch r.LoadBalanceMethod {
+		case "":
+			r.LoadBalanceMethod = loadBalanceMethod
+		case LBMWRR, LBMRR, LBMRandom:
+		default:
+			return errBadLoadBalanceAlgorithm
+		}
+	}
+
+	return nil
+}
+
+// matcher matches a header, query argument or cookie of request
+type matcher struct {
+	source string
+	name   string
+	typ    string
+	value  []byte
+	regexp *regexp.Regexp
+}
+
+func newMatcher(c *matcherConfig) (*matcher, error) {
+	switch c.Source {
+	case matchHeader, matchQuery, matchCookie:
+	default:
+		return nil, errBadMatchSource
+	}
+	if c.Name == "" {
+		return nil, errMatchNameEmpty
+	}
+
+	m := &matcher{source: c.Source, name: c.Name, typ: c.Type, value: []byte(c.Value)}
+	switch c.Type {
+	case "", matchExact:
+		m.typ = matchExact
+	case matchPrefix:
+	case matchRegex:
+		re, err := regexp.Compile(c.Value)
+		if err != nil {
+			return nil, err
+		}
+		m.regexp = re
+	default:
+		return nil, errBadMatchType
+	}
+
+	return m, nil
+}
+
+// matches return true if the request matches, missing header, query or cookie never matches
+func (m *matcher) matches(ctx *fasthttp.RequestCtx) bool {
+	var value []byte
+	var present bool
+	switch m.source {
+	case matchHeader:
+		value = ctx.Request.Header.Peek(m.name)
+		present = value != nil
+	case matchQuery:
+		// value of argument without value, e.g. `debug` in `?debug&v=2`, is empty
+		args := ctx.QueryArgs()
+		value, present = args.Peek(m.name), args.Has(m.name)
+	case matchCookie:
+		value = ctx.Request.Header.Cookie(m.name)
+		present = value != nil
+	}
+	if !present {
+		return false
+	}
+
+	switch m.typ {
+	case matchPrefix:
+		return bytes.HasPrefix(value, m.value)
+	case matchRegex:
+		return m.regexp.Match(value)
+	default:
+		return bytes.Equal(value, m.value)
+	}
+}
+
+// rule proxies requests which match all of its matchers to group
+type rule struct {
+	matchers []*matcher
+	group    *backendGroup
+}
+
+// route is what a leaf of radix tree belongs to, e.g. /user/:name
+type route struct {
+	path     string
+	rules    []rule
+	balancer Balancer      // backends of the route, nil if it shares backends of the app
+	timeout  time.Duration // 0 means no timeout
+	rewrite  *rewrite      // nil if path of requests is not rewritten
+}
+
+func newRoute(path []byte) *route {
+	return &route{path: string(path)}
+}
+
+// ownBalancer return balancer of the route's own backends, nil if it has none
+func (r *route) ownBalancer() Balancer {
+	if r == nil {
+		return nil
+	}
+	return r.balancer
+}
+
+func (r *route) proxyTimeout() time.Duration {
+	if r == nil {
+		return 0
+	}
+	return r.timeout
+}
+
+var bufPool = sync.Pool{New: func() interface{} { buf := make([]byte, 0, 256); return &buf }}
+
+func acquireBuf() *[]byte {
+	return bufPool.Get().(*[]byte)
+}
+
+func releaseBuf(buf *[]byte) {
+	*buf = (*buf)[:0]
+	bufPool.Put(buf)
+}
+
+// forward set pattern of the route in header routeHeader, and every wildcard captured from the
+// path in header paramPrefix + its name, headers with empty name are not set. headers of the
+// same names sent by the client are removed, so they can't be spoofed
+func (r *route) forward(ctx *fasthttp.RequestCtx, ps params, routeHeader string, paramPrefix []byte) {
+	stripForwarded(&ctx.Request.Header, routeHeader, paramPrefix)
+	if r == nil {
+		return
+	}
+	if routeHeader != "" {
+		ctx.Request.Header.Set(routeHeader, r.path)
+	}
+	if len(paramPrefix) == 0 || len(ps) == 0 {
+		return
+	}
+
+	keyp, valuep := acquireBuf(), acquireBuf()
+	key := append(*keyp, paramPrefix...)
+	value := *valuep
+	for _, p := range ps {
+		key = append(key[:len(paramPrefix)], p.key...)
+		value = appendHeaderValue(value[:0], p.value)
+		ctx.Request.Header.SetBytesKV(key, value)
+	}
+	*keyp, *valuep = key, value
+	releaseBuf(keyp)
+	releaseBuf(valuep)
+}
+
+// stripForwarded delete header routeHeader and headers whose names begin with paramPrefix
+func stripForwarded(h *fasthttp.RequestHeader, routeHeader string, paramPrefix []byte) {
+	if routeHeader == "" && len(paramPrefix) == 0 {
+		return
+	}
+
+	// names are collected and separated by '\n', the header can't be changed while visiting
+	namesp := acquireBuf()
+	names := *namesp
+	h.VisitAll(func(key, _ []byte) {
+		if (len(paramPrefix) > 0 && hasPrefixFold(key, paramPrefix)) ||
+			(len(key) == len(routeHeader) && strings.EqualFold(string(key), routeHeader)) {
+			names = append(append(names, key...), '\n')
+		}
+	})
+	// every occurrence is deleted once, in case some duplicates survive a deletion
+	for rest := names; len(rest) > 0; {
+		i := bytes.IndexByte(rest, '\n')
+		h.DelBytes(rest[:i])
+		rest = rest[i+1:]
+	}
+	*namesp = names
+	releaseBuf(namesp)
+}
+
+// hasPrefixFold report whether s begins with prefix, ignoring case
+func hasPrefixFold(s, prefix []byte) bool {
+	if len(s) < len(prefix) {
+		return false
+	}
+	for i, c := range prefix {
+		if lower(s[i]) != lower(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// appendHeaderValue append value to dst, wildcards are decoded from path, so control characters
+// in it are percent-encoded to keep the header valid
+func appendHeaderValue(dst, value []byte) []byte {
+	const hex = "0123456789ABCDEF"
+	for _, c := range value {
+		if c < 0x20 || c == 0x7f {
+			dst = append(dst, '%', hex[c>>4], hex[c&0xf])
+		} else {
+			dst = append(dst, c)
+		}
+	}
+	return dst
+}
+
+// match return group of the first rule which the request matches, nil if none of them matches
+func (r *route) match(ctx *fasthttp.RequestCtx) *backendGroup {
+	if r == nil {
+		return nil
+	}
+
+walk:
+	for i := range r.rules {
+		for _, m := range r.rules[i].matchers {
+			if !m.matches(ctx) {
+				continue walk
+			}
+		}
+		return r.rules[i].group
+	}
+
+	return nil
+}
+
+// SetRouteTarget let route of path proxy requests to its own backends, or backends of the app
+// if balancer is nil, and fail if the backend does not respond in timeout
+func (a *Application) SetRouteTarget(path string, balancer Balancer, timeout time.Duration) error {
+	route, exist := a.routes[path]
+	if !exist {
+		return errRuleRouteNotFound
+	}
+
+	route.balancer, route.timeout = balancer, timeout
+	return nil
+}
+
+// AddRule add a rule to the route of its path, or every route if path is empty, rules should
+// be added before the application serves
+func (a *Application) AddRule(c ruleConfig) error {
+	split := a.groupSplit()
+	if split == nil {
+		return errRuleGroupNotFound
+	}
+	group := split.byName([]byte(c.Group))
+	if group == nil {
+		return errRuleGroupNotFound
+	}
+
+	if len(c.Match) == 0 {
+		return errRuleMatchEmpty
+	}
+	r := rule{group: group}
+	for i := range c.Match {
+		m, err := newMatcher(&c.Match[i])
+		if err != nil {
+			return err
+		}
+		r.matchers = append(r.matchers, m)
+	}
+
+	if c.Path == "" {
+		for _, route := range a.routes {
+			route.rules = append(route.rules, r)
+		}
+		a.rules = append(a.rules, r)
+		return nil
+	}
+
+	route, exist := a.routes[c.Path]
+	if !exist {
+		return errRuleRouteNotFound
+	}
+	route.rules = append(route.rules, r)
+
+	return nil
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestMatcher(t *testing.T) {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/api/users?version=2&debug=")
+	ctx.Request.Header.Set("X-Api-Version", "2.1")
+	ctx.Request.Header.SetCookie("beta", "yes")
+
+	expects := []struct {
+		config  matcherConfig
+		matches bool
+	}{
+		{matcherConfig{Source: matchHeader, Name: "X-Api-Version", Value: "2.1"}, true},
+		{matcherConfig{Source: matchHeader, Name: "x-api-version", Value: "2"}, false},
+		{matcherConfig{Source: matchHeader, Name: "X-Api-Version", Type: matchPrefix, Value: "2"}, true},
+		{matcherConfig{Source: matchHeader, Name: "X-Api-Version", Type: matchRegex, Value: `^2\.[0-9]+$`}, true},
+		{matcherConfig{Source: matchHeader, Name: "X-Missing", Type: matchPrefix, Value: ""}, false},
+		{matcherConfig{Source: matchQuery, Name: "version", Value: "2"}, true},
+		{matcherConfig{Source: matchQuery, Name: "debug", Value: ""}, true},
+		{matcherConfig{Source: matchQuery, Name: "what", Value: ""}, false},
+		{matcherConfig{Source: matchCookie, Name: "beta", Type: matchExact, Value: "yes"}, true},
+		{matcherConfig{Source: matchCookie, Name: "beta", Type: matchRegex, Value: "^no"}, false},
+	}
+	for i, e := range expects {
+		m, err := newMatcher(&e.config)
+		if err != nil {
+			t.Fatalf("the %dth matcher should not return error but got: %s", i, err)
+		}
+		if matches := m.matches(ctx); matches != e.matches {
+			t.Errorf("the %dth matcher should return %t but got: %t", i, e.matches, matches)
+		}
+	}
+
+	for _, c := range []matcherConfig{
+		{Source: "body", Name: "version"},
+		{Source: matchHeader},
+		{Source: matchHeader, Name: "X-Api-Version", Type: "suffix"},
+		{Source: matchHeader, Name: "X-Api-Version", Type: matchRegex, Value: "("},
+	} {
+		if _, err := newMatcher(&c); err == nil {
+			t.Errorf("should return error for %+v but not", c)
+		}
+	}
+}
+
+func TestCheckRuleConfigs(t *testing.T) {
+	paths := []string{"/api/*path"}
+	groups := []groupConfig{{Name: "v2"}}
+	match := []matcherConfig{{Source: matchHeader, Name: "X-Api-Version", Value: "2"}}
+
+	expects := []struct {
+		rule ruleConfig
+		err  error
+	}{
+		{ruleConfig{Path: "/api/*path", Match: match, Group: "v2"}, nil},
+		{ruleConfig{Match: match, Group: stableGroup}, nil},
+		{ruleConfig{Path: "/what", Match: match, Group: "v2"}, errRuleRouteNotFound},
+		{ruleConfig{Path: "/api/*path", Match: match, Group: "v3"}, errRuleGroupNotFound},
+		{ruleConfig{Path: "/api/*path", Group: "v2"}, errRuleMatchEmpty},
+		{ruleConfig{Path: "/api/*path", Match: []matcherConfig{{Source: "body", Name: "a"}}, Group: "v2"}, errBadMatchSource},
+	}
+	for i, e := range expects {
+		if err := checkRuleConfigs([]ruleConfig{e.rule}, paths, groups); err != e.err {
+			t.Errorf("the %dth rule should return %v but got: %v", i, e.err, err)
+		}
+	}
+}
+
+func TestRouteRules(t *testing.T) {
+	a := NewApp(NewRR(NewBackend("192.168.1.1:80", 1)), true)
+	a.AddRoute("/api/*path", "GET")
+	a.AddRoute("/user/:name", "GET")
+	a.AddRoute("/users", "GET") // split the node of /user/:name
+
+	if err := a.AddRule(ruleConfig{Path: "/api/*path", Match: []matcherConfig{{Source: matchHeader, Name: "X-Api-Version", Value: "2"}}, Group: "v2"}); err != errRuleGroupNotFound {
+		t.Errorf("should return %s but got: %s", errRuleGroupNotFound, err)
+	}
+
+	v2 := newBackendGroup("v2", NewRR(NewBackend("192.168.2.1:80", 1)))
+	v3 := newBackendGroup("v3", NewRR(NewBackend("192.168.3.1:80", 1)))
+	a.setGroups([]*backendGroup{v2, v3}, []int{0, 0}, "", "")
+
+	rules := []ruleConfig{
+		{Path: "/api/*path", Match: []matcherConfig{{Source: matchHeader, Name: "X-Api-Version", Value: "2"}}, Group: "v2"},
+		// both of the first and this one match, but the first one wins
+		{Path: "/api/*path", Match: []matcherConfig{{Source: matchQuery, Name: "v", Value: "3"}}, Group: "v3"},
+		{Match: []matcherConfig{{Source: matchCookie, Name: "beta", Value: "yes"}, {Source: matchQuery, Name: "v", Value: "3"}}, Group: "v3"},
+	}
+	for _, r := range rules {
+		if err := a.AddRule(r); err != nil {
+			t.Fatalf("should not return error but got: %s", err)
+		}
+	}
+	if err := a.AddRule(ruleConfig{Path: "/what", Match: rules[0].Match, Group: "v2"}); err != errRuleRouteNotFound {
+		t.Errorf("should return %s but got: %s", errRuleRouteNotFound, err)
+	}
+
+	expects := []struct {
+		uri    string
+		header string
+		cookie string
+		group  *backendGroup
+	}{
+		{"/api/users", "2", "", v2},
+		{"/api/users?v=3", "2", "", v2},
+		{"/api/users?v=3", "", "", v3},
+		{"/api/users", "", "", nil},
+		{"/user/jhon?v=3", "2", "", nil},
+		{"/user/jhon?v=3", "", "yes", v3},
+		{"/users?v=3", "", "yes", v3},
+	}
+	for _, e := range expects {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(e.uri)
+		if e.header != "" {
+			ctx.Request.Header.Set("X-Api-Version", e.header)
+		}
+		if e.cookie != "" {
+			ctx.Request.Header.SetCookie("beta", e.cookie)
+		}
+
+		n, _, found := a.tree().byPath(ctx.Path())
+		if !found {
+			t.Fatalf("route of %s should be found", e.uri)
+		}
+		if g := n.route.match(ctx); g != e.group {
+			t.Errorf("%s with header %s and cookie %s should be proxied to %+v but got: %+v", e.uri, e.header, e.cookie, e.group, g)
+		}
+	}
+
+	if n, _, _ := a.tree().byPath([]byte("/user/jhon")); n.route.path != "/user/:name" {
+		t.Errorf("path of route should be /user/:name, but got: %s", n.route.path)
+	}
+}
+
+func TestRouteForward(t *testing.T) {
+	a := NewApp(NewRR(NewBackend("192.168.1.1:80", 1)), true)
+	a.AddRoute("/user/:name/*path", "GET")
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/user/jhon%0D%0AX-Evil:%201/cards/1")
+	ctx.Request.Header.Set("X-Guard-Route", "/spoofed")
+	ps := acquireParams()
+	defer releaseParams(ps)
+	n, _, _ := a.tree().lookup(ctx.Path(), ps)
+
+	n.route.forward(ctx, *ps, "", nil)
+	if route := string(ctx.Request.Header.Peek("X-Guard-Route")); route != "/spoofed" {
+		t.Errorf("nothing should be forwarded, but got: %s", route)
+	}
+
+	n.route.forward(ctx, *ps, "X-Guard-Route", []byte("X-Guard-Param-"))
+	expects := map[string]string{
+		"X-Guard-Route":      "/user/:name/*path",
+		"X-Guard-Param-Name": "jhon%0D%0AX-Evil: 1",
+		"X-Guard-Param-Path": "cards/1",
+	}
+	for key, value := range expects {
+		if v := string(ctx.Request.Header.Peek(key)); v != value {
+			t.Errorf("header %s should be %s, but got: %s", key, value, v)
+		}
+	}
+	if evil := ctx.Request.Header.Peek("X-Evil"); evil != nil {
+		t.Errorf("header should not be injected, but got: %s", evil)
+	}
+}
+
+func TestRouteForwardSpoofed(t *testing.T) {
+	a := NewApp(NewRR(NewBackend("192.168.1.1:80", 1)), true)
+	a.AddRoute("/static", "GET")
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/static")
+	ctx.Request.Header.Add("X-Guard-Route", "/spoofed")
+	ctx.Request.Header.Add("x-guard-route", "/spoofed")
+	ctx.Request.Header.Add("X-Guard-Param-Name", "admin")
+	ctx.Request.Header.Add("x-guard-param-id", "1")
+	ctx.Request.Header.Add("x-guard-param-id", "2")
+	ctx.Request.Header.Set("X-Guard-Other", "kept")
+	ps := acquireParams()
+	defer releaseParams(ps)
+	n, _, _ := a.tree().lookup(ctx.Path(), ps)
+
+	// no params are captured
+	n.route.forward(ctx, *ps, "X-Guard-Route", []byte("X-Guard-Param-"))
+	var routes []string
+	ctx.Request.Header.VisitAll(func(key, value []byte) {
+		switch k := strings.ToLower(string(key)); {
+		case k == "x-guard-route":
+			routes = append(routes, string(value))
+		case strings.HasPrefix(k, "x-guard-param-"):
+			t.Errorf("spoofed header should be removed, but got: %s: %s", key, value)
+		}
+	})
+	if len(routes) != 1 || routes[0] != "/static" {
+		t.Errorf("route should be the only one forwarded, but got: %v", routes)
+	}
+	if other := string(ctx.Request.Header.Peek("X-Guard-Other")); other != "kept" {
+		t.Errorf("other headers should be kept, but got: %s", other)
+	}
+}
+
+func BenchmarkRouteForward(b *testing.B) {
+	a := NewApp(NewRR(NewBackend("192.168.1.1:80", 1)), true)
+	a.AddRoute("/user/:name/*path", "GET")
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/user/jhon/cards/1")
+	ps := acquireParams()
+	n, _, _ := a.tree().lookup(ctx.Path(), ps)
+	prefix := []byte("X-Guard-Param-")
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		n.route.forward(ctx, *ps, "X-Guard-Route", prefix)
+	}
+}
+
+func TestCheckRouteConfigs(t *testing.T) {
+	paths := []string{"/api/*path", "/static/*path"}
+
+	routes := []routeConfig{{Path: "/api/*path", Backends: []string{"192.168.2.1:80"}, Weights: []int{1}}}
+	if err := checkRouteConfigs(routes, paths, LBMWRR); err != nil || routes[0].LoadBalanceMethod != LBMWRR {
+		t.Errorf("load balance method of route should be the app's, but got: %+v, %s", routes[0], err)
+	}
+
+	expects := []struct {
+		routes []routeConfig
+		err    error
+	}{
+		{[]routeConfig{{Path: "/what"}}, errRuleRouteNotFound},
+		{[]routeConfig{{Path: "/api/*path"}, {Path: "/api/*path"}}, errRouteExists},
+		{[]routeConfig{{Path: "/api/*path", Backends: []string{"192.168.2.1:80"}}}, errBackendWeightNotMatch},
+		{[]routeConfig{{Path: "/api/*path", Timeout: -1}}, errBadRouteTimeout},
+		{[]routeConfig{{Path: "/api/*path", LoadBalanceMethod: "what"}}, errBadLoadBalanceAlgorithm},
+	}
+	for i, e := range expects {
+		if err := checkRouteConfigs(e.routes, paths, LBMRR); err != e.err {
+			t.Errorf("the %dth routes should return %v but got: %v", i, e.err, err)
+		}
+	}
+}
+
+func TestRouteTarget(t *testing.T) {
+	app, stopApp := inmemoryBackend(t, func(ctx *fasthttp.RequestCtx) { ctx.WriteString("app") })
+	defer stopApp()
+	api, stopAPI := inmemoryBackend(t, func(ctx *fasthttp.RequestCtx) { ctx.WriteString("api") })
+	defer stopAPI()
+	slow, stopSlow := inmemoryBackend(t, func(ctx *fasthttp.RequestCtx) {
+		time.Sleep(200 * time.Millisecond)
+		ctx.WriteString("slow")
+	})
+	defer stopSlow()
+
+	a := NewApp(NewRR(app), true)
+	a.AddRoute("/", "GET")
+	a.AddRoute("/api/*path", "GET")
+	a.AddRoute("/slow", "GET")
+
+	if err := a.SetRouteTarget("/what", nil, 0); err != errRuleRouteNotFound {
+		t.Errorf("should return %s but got: %s", errRuleRouteNotFound, err)
+	}
+	a.SetRouteTarget("/api/*path", NewRR(api), 0)
+	a.SetRouteTarget("/slow", NewRR(slow), 50*time.Millisecond)
+
+	// routes with their own backends are not split between groups
+	a.setGroups([]*backendGroup{newBackendGroup("canary", NewRR(api))}, []int{100}, "", "")
+
+	for _, e := range []struct {
+		path string
+		body string
+	}{
+		{"/api/users", "api"},
+		{"/", "api"}, // split to canary
+		{"/slow", ""},
+	} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("http://example.com" + e.path)
+		a.ServeHTTP(ctx)
+		if body := string(ctx.Response.Body()); body != e.body {
+			t.Errorf("response of %s should be %s, but got: %s", e.path, e.body, body)
+		}
+	}
+
+	if _, failures, _ := slow.stats.query(); failures != 1 {
+		t.Errorf("request which timed out should be a failure, but got %d failures", failures)
+	}
+}
+
+func TestRouteConfig(t *testing.T) {
+	config := &appConfig{
+		Name: "route.example.com", Backends: []string{"192.168.1.1:80"}, Weights: []int{1},
+		Paths: []string{"/api/*path", "/static/*path"}, Methods: []string{"GET", "GET"},
+		Routes: []routeConfig{{Path: "/api/*path", Backends: []string{"192.168.2.1:80"}, Weights: []int{1}, LoadBalanceMethod: LBMWRR, Timeout: 500}},
+	}
+	if err := checkAppConfig(config); err != nil {
+		t.Fatalf("should not return error but got: %s", err)
+	}
+
+	a, _ := getAPP(config)
+	api, static := a.routes["/api/*path"], a.routes["/static/*path"]
+	if _, ok := api.balancer.(*WRR); !ok || api.timeout != 500*time.Millisecond {
+		t.Errorf("route should have its own backends and timeout, but got: %+v", api)
+	}
+	if static.balancer != nil || static.timeout != 0 {
+		t.Errorf("route should share backends of the app, but got: %+v", static)
+	}
+}
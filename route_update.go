@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+/*
+routes of a running application can be added, updated and removed. the radix tree which is
+serving is never modified, a copy of it is changed and then replaces it, leaves of the copy
+share rings of status with the old ones, so routes which are not touched keep their stats.
+*/
+
+var (
+	errRoutePathEmpty = errors.New("path of route is required")
+	errRouteNotFound  = errors.New("route not found")
+)
+
+type routeUpdateConfig struct {
+	Name    string   `json:"name"`    // name of the app
+	Path    string   `json:"path"`    // e.g. /user/:name
+	Methods []string `json:"methods"` // e.g. ["GET", "POST"], methods of the route are replaced
+}
+
+// SetRoute add a route to the running application, or replace methods of the route if it
+// exists, stats of the route are kept
+func (a *Application) SetRoute(path string, methods ...string) error {
+	if path == "" {
+		return errRoutePathEmpty
+	}
+
+	// methods are case-insensitive in configuration, as what getAPP does
+	upper := make([]string, len(methods))
+	for i, m := range methods {
+		upper[i] = strings.ToUpper(m)
+	}
+	httpMethods, err := convertMethod(upper...)
+	if err != nil {
+		return &pathError{path: path, err: err, detail: strings.Join(methods, ",")}
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	// the copy is dropped if the path is bad
+	root := a.tree().clone()
+	leaf, err := root.addRoute([]byte(path), httpMethods)
+	if err != nil {
+		return err
+	}
+	leaf.methods = httpMethods
+
+	if _, exist := a.routes[path]; !exist {
+		leaf.route.rules = append(leaf.route.rules, a.rules...)
+		a.routes[path] = leaf.route
+	}
+	a.root.Store(root)
+
+	a.syncRoutes(path, methods)
+	return nil
+}
+
+// RemoveRoute remove the route from the running application
+func (a *Application) RemoveRoute(path string) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	r, exist := a.routes[path]
+	if !exist {
+		return errRouteNotFound
+	}
+
+	root := a.tree().clone()
+	if !root.removeRoute([]byte(path)) {
+		return errRouteNotFound
+	}
+	delete(a.routes, path)
+	a.root.Store(root)
+
+	// own backends of the route are gone with it
+	if balancer := r.ownBalancer(); balancer != nil {
+		a.detector.unwatch(balancer.Backends()...)
+	}
+
+	a.syncRoutes(path, nil)
+	return nil
+}
+
+// syncRoutes replace methods of path in configuration, and remove it if methods is empty. it
+// should be called with a.lock held
+func (a *Application) syncRoutes(path string, methods []string) {
+	if a.config == nil {
+		return
+	}
+
+	a.config.setRoute(path, methods)
+	config := *a.config
+	go func() { configSync <- config }()
+}
+
+// setRoute replace methods of path, rules and routes of path are removed if methods is empty
+func (c *appConfig) setRoute(path string, methods []string) {
+	paths, httpMethods := []string{}, []string{}
+	for i := range c.Paths {
+		if c.Paths[i] != path {
+			paths = append(paths, c.Paths[i])
+			httpMethods = append(httpMethods, c.Methods[i])
+		}
+	}
+	for _, m := range methods {
+		paths = append(paths, path)
+		httpMethods = append(httpMethods, strings.ToUpper(m))
+	}
+	c.Paths, c.Methods = paths, httpMethods
+
+	if len(methods) > 0 {
+		return
+	}
+
+	rules, routes := []ruleConfig{}, []routeConfig{}
+	for _, r := range c.Rules {
+		if r.Path != path {
+			rules = append(rules, r)
+		}
+	}
+	for _, r := range c.Routes {
+		if r.Path != path {
+			routes = append(routes, r)
+		}
+	}
+	c.Rules, c.Routes = rules, routes
+}
+
+// routeHandler add or update a route by POST or PUT, and remove it by DELETE
+func routeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" && r.Method != "PUT" && r.Method != "DELETE" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	defer r.Body.Close()
+	var config routeUpdateConfig
+
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	app, exist := breaker.get(config.Name)
+	if !exist {
+		writeError(w, http.StatusNotFound, errAPPNotFound)
+		return
+	}
+
+	var err error
+	if r.Method == "DELETE" {
+		err = app.RemoveRoute(config.Path)
+	} else {
+		err = app.SetRoute(config.Path, config.Methods...)
+	}
+
+	switch err {
+	case nil:
+		w.Write([]byte("success!"))
+	case errRouteNotFound:
+		writeError(w, http.StatusNotFound, err)
+	default:
+		writeError(w, http.StatusBadRequest, err)
+	}
+}
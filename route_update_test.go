@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestSetRoute(t *testing.T) {
+	a := NewApp(NewRR(NewBackend("192.168.1.1:80", 1)), true)
+	a.AddRoute("/user/:name", "GET")
+	a.AddRoute("/api/*path", "GET")
+	a.setGroups([]*backendGroup{newBackendGroup("v2", NewRR(NewBackend("192.168.2.1:80", 1)))}, []int{0}, "", "")
+	a.AddRule(ruleConfig{Match: []matcherConfig{{Source: matchHeader, Name: "X-Api-Version", Value: "2"}}, Group: "v2"})
+
+	old := a.tree()
+	user, _, _ := old.byPath([]byte("/user/jhon"))
+	user.incr(fasthttp.StatusOK)
+
+	if err := a.SetRoute("/users", "GET", "POST"); err != nil {
+		t.Fatalf("should not return error but got: %s", err)
+	}
+	if err := a.SetRoute("/user/:name", "delete"); err != nil {
+		t.Fatalf("should not return error but got: %s", err)
+	}
+
+	// the tree which was serving is not changed
+	if _, _, found := old.byPath([]byte("/users")); found {
+		t.Errorf("old tree should not be changed")
+	}
+
+	n, _, found := a.tree().byPath([]byte("/users"))
+	if !found || !n.hasMethod(POST) || len(n.route.rules) != 1 || a.routes["/users"] != n.route {
+		t.Errorf("route should be added with rules for every route, but got: %+v", n)
+	}
+
+	n, _, _ = a.tree().byPath([]byte("/user/jhon"))
+	if n.hasMethod(GET) || !n.hasMethod(DELETE) {
+		t.Errorf("methods of route should be replaced, but got: %x", n.methods)
+	}
+	if requests, _, _, _, _ := n.query(); requests != 1 || n.route != user.route {
+		t.Errorf("route should keep its stats, but got %d requests", requests)
+	}
+
+	for _, e := range []struct {
+		path    string
+		methods []string
+	}{
+		{"", []string{"GET"}},
+		{"/user/:id", []string{"GET"}},
+		{"/orders", []string{"WHAT"}},
+		{"/orders", nil},
+	} {
+		if err := a.SetRoute(e.path, e.methods...); err == nil {
+			t.Errorf("route %s with methods %v should return error, but not", e.path, e.methods)
+		}
+	}
+	if _, exist := a.routes["/orders"]; exist {
+		t.Errorf("bad route should not be added")
+	}
+}
+
+func TestRemoveRoute(t *testing.T) {
+	a := NewApp(NewRR(NewBackend("192.168.1.1:80", 1)), true)
+	a.AddRoute("/user/:name", "GET")
+	a.AddRoute("/users", "GET")
+	a.AddRoute("/api/*path", "GET")
+
+	api, _, _ := a.tree().byPath([]byte("/api/users"))
+	api.incr(fasthttp.StatusOK)
+
+	if err := a.RemoveRoute("/users"); err != nil {
+		t.Fatalf("should not return error but got: %s", err)
+	}
+	if err := a.RemoveRoute("/users"); err != errRouteNotFound {
+		t.Errorf("should return %s but got: %s", errRouteNotFound, err)
+	}
+
+	if _, _, found := a.tree().byPath([]byte("/users")); found {
+		t.Errorf("route should be removed")
+	}
+	if _, exist := a.routes["/users"]; exist {
+		t.Errorf("route should be removed")
+	}
+
+	n, _, found := a.tree().byPath([]byte("/api/users"))
+	if requests, _, _, _, _ := n.query(); !found || requests != 1 {
+		t.Errorf("route should keep its stats, but got %d requests", requests)
+	}
+}
+
+func TestRemoveRouteUnwatch(t *testing.T) {
+	backend := NewBackend("192.168.1.1:80", 1)
+	a := NewApp(NewRR(backend), true)
+	a.detector = newOutlierDetector(&outlierConfig{})
+	a.watch(backend)
+	a.AddRoute("/api/*path", "GET")
+
+	own := []Backend{NewBackend("192.168.2.1:80", 1), NewBackend("192.168.2.2:80", 1)}
+	a.watch(own...)
+	a.SetRouteTarget("/api/*path", NewRR(own...), 0)
+
+	if err := a.RemoveRoute("/api/*path"); err != nil {
+		t.Fatalf("should not return error but got: %s", err)
+	}
+	if pool := a.detector.pool; len(pool) != 1 || pool[0] != backend.stats {
+		t.Errorf("own backends of the route should be unwatched, but got %d backends", len(pool))
+	}
+}
+
+func TestConfigSetRoute(t *testing.T) {
+	config := &appConfig{
+		Paths:   []string{"/user/:name", "/user/:name", "/api/*path"},
+		Methods: []string{"GET", "POST", "GET"},
+		Rules:   []ruleConfig{{Path: "/api/*path"}, {Path: ""}},
+		Routes:  []routeConfig{{Path: "/api/*path"}},
+	}
+
+	config.setRoute("/user/:name", []string{"delete"})
+	if len(config.Paths) != 2 || config.Paths[1] != "/user/:name" || config.Methods[1] != "DELETE" {
+		t.Errorf("methods of route should be replaced, but got: %v, %v", config.Paths, config.Methods)
+	}
+
+	config.setRoute("/api/*path", nil)
+	if len(config.Paths) != 1 || len(config.Rules) != 1 || len(config.Routes) != 0 {
+		t.Errorf("route should be removed, but got: %+v", config)
+	}
+}
+
+func TestRouteHandler(t *testing.T) {
+	fakeServer := httptest.NewServer(http.HandlerFunc(routeHandler))
+	defer fakeServer.Close()
+	url := fakeServer.URL + "/app/route"
+
+	appName := "route.example.com"
+	a := NewApp(NewRR(NewBackend("192.168.1.1:80", 1)), true)
+	a.AddRoute("/user/:name", "GET")
+	breaker.apps[appName] = a
+	defer delete(breaker.apps, appName)
+
+	expects := []struct {
+		method string
+		body   string
+		code   int
+	}{
+		{"POST", `{"name":"route.example.com","path":"/users","methods":["GET"]}`, http.StatusOK},
+		{"PUT", `{"name":"route.example.com","path":"/users","methods":["GET","POST"]}`, http.StatusOK},
+		{"POST", `{"name":"route.example.com","path":"/user/:id","methods":["GET"]}`, http.StatusBadRequest},
+		{"DELETE", `{"name":"route.example.com","path":"/user/:name"}`, http.StatusOK},
+		{"DELETE", `{"name":"route.example.com","path":"/user/:name"}`, http.StatusNotFound},
+		{"POST", `{"name":"what.example.com","path":"/users","methods":["GET"]}`, http.StatusNotFound},
+		{"POST", `what`, http.StatusBadRequest},
+		{"GET", ``, http.StatusMethodNotAllowed},
+	}
+	for i, e := range expects {
+		req, _ := http.NewRequest(e.method, url, bytes.NewBufferString(e.body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to request route handler: %s", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != e.code {
+			t.Errorf("the %dth request should return %d but got: %d", i, e.code, resp.StatusCode)
+		}
+	}
+
+	if n, _, found := a.tree().byPath([]byte("/users")); !found || !n.hasMethod(POST) {
+		t.Errorf("route should be updated")
+	}
+}
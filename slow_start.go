@@ -0,0 +1,95 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sync/atomic"
+)
+
+/*
+slow start, a newly added or recovered backend does not receive its full share of traffic
+at once, its effective weight ramps up from a small share to its configured weight during the
+slow start window, so cold caches have time to warm up. it's borrowed from Envoy:
+https://www.envoyproxy.io/docs/envoy/latest/intro/arch_overview/upstream/load_balancing/slow_start
+
+    effective weight = weight * max(min_weight_percent / 100, (elapsed / duration) ^ (1 / aggression))
+
+aggression 1 means the weight grows linearly, the larger it is, the faster the weight grows
+at the beginning.
+*/
+
+const (
+	defaultMinWeightPercent = 10
+	defaultAggression       = 1.0
+)
+
+type slowStartConfig struct {
+	Duration         int64   `json:"duration"`           // in seconds
+	MinWeightPercent int     `json:"min_weight_percent"` // effective weight starts from it
+	Aggression       float64 `json:"aggression"`         // 1 means linear
+}
+
+func checkSlowStartConfig(c *slowStartConfig) error {
+	if c.Duration <= 0 {
+		return errBadSlowStartDuration
+	}
+	if c.MinWeightPercent < 0 || c.MinWeightPercent > 100 {
+		return errBadSlowStartMinWeight
+	}
+	if c.Aggression < 0 {
+		return errBadSlowStartAggression
+	}
+
+	if c.MinWeightPercent == 0 {
+		c.MinWeightPercent = defaultMinWeightPercent
+	}
+	if c.Aggression == 0 {
+		c.Aggression = defaultAggression
+	}
+
+	return nil
+}
+
+// warmFactor return how many percent of its weight the backend should have now, a backend
+// is warming up after it's added, or after its ejection is over.
+func (s *backendStats) warmFactor(now int64) float64 {
+	c := s.slowStart
+	if c == nil {
+		return 1
+	}
+
+	since := atomic.LoadInt64(&s.addedAt)
+	if ejectedUntil := atomic.LoadInt64(&s.ejectedUntil); ejectedUntil > since {
+		since = ejectedUntil
+	}
+
+	elapsed := now - since
+	if elapsed >= c.Duration {
+		return 1
+	}
+	if elapsed < 0 {
+		elapsed = 0
+	}
+
+	return math.Max(
+		float64(c.MinWeightPercent)/100,
+		math.Pow(float64(elapsed)/float64(c.Duration), 1/c.Aggression),
+	)
+}
+
+// warmFactor return 1 if the backend is not in slow start window
+func (b *Backend) warmFactor(now int64) float64 {
+	if b.stats == nil {
+		return 1
+	}
+
+	return b.stats.warmFactor(now)
+}
+
+// admit return false if the backend should be skipped this time because it's warming up,
+// so it receives only a share of the traffic it should receive. this works for all balancers.
+func (b *Backend) admit(now int64) bool {
+	factor := b.warmFactor(now)
+
+	return factor >= 1 || rand.Float64() < factor
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCheckSlowStartConfig(t *testing.T) {
+	c := &slowStartConfig{Duration: 60}
+	if err := checkSlowStartConfig(c); err != nil {
+		t.Errorf("should not return error but got: %s", err)
+	}
+	if c.MinWeightPercent != defaultMinWeightPercent || c.Aggression != defaultAggression {
+		t.Errorf("slow start config should be set to default, but got: %+v", c)
+	}
+
+	for _, c := range []*slowStartConfig{
+		&slowStartConfig{},
+		&slowStartConfig{Duration: 60, MinWeightPercent: 101},
+		&slowStartConfig{Duration: 60, Aggression: -1},
+	} {
+		if err := checkSlowStartConfig(c); err == nil {
+			t.Errorf("should return error but not: %+v", c)
+		}
+	}
+}
+
+func TestWarmFactor(t *testing.T) {
+	now := CoarseTimeNow().Unix()
+	b := NewBackend("192.168.1.1:80", 10)
+
+	// slow start is disabled
+	b.stats.addedAt = now
+	if f := b.warmFactor(now); f != 1 {
+		t.Errorf("warm factor should be 1 if slow start is disabled, but got: %f", f)
+	}
+
+	expects := []struct {
+		config  slowStartConfig
+		elapsed int64
+		factor  float64
+	}{
+		{slowStartConfig{Duration: 100, MinWeightPercent: 10, Aggression: 1}, 0, 0.1},
+		{slowStartConfig{Duration: 100, MinWeightPercent: 10, Aggression: 1}, 50, 0.5},
+		{slowStartConfig{Duration: 100, MinWeightPercent: 10, Aggression: 1}, 100, 1},
+		{slowStartConfig{Duration: 100, MinWeightPercent: 10, Aggression: 2}, 25, 0.5},
+		{slowStartConfig{Duration: 100, MinWeightPercent: 60, Aggression: 2}, 25, 0.6},
+	}
+	for _, e := range expects {
+		config := e.config
+		b.stats.slowStart = &config
+		b.stats.addedAt = now - e.elapsed
+
+		if f := b.warmFactor(now); math.Abs(f-e.factor) > 1e-9 {
+			t.Errorf("warm factor of %+v after %d seconds should be %f, but got: %f", e.config, e.elapsed, e.factor, f)
+		}
+	}
+
+	// backends which are there since the app was built are not warming up
+	b.stats.addedAt = 0
+	if f := b.warmFactor(now); f != 1 {
+		t.Errorf("warm factor should be 1, but got: %f", f)
+	}
+
+	// recovered backends should warm up again
+	b.stats.slowStart = &slowStartConfig{Duration: 100, MinWeightPercent: 10, Aggression: 1}
+	b.stats.ejectedUntil = now - 50
+	if f := b.warmFactor(now); f != 0.5 {
+		t.Errorf("warm factor of recovered backend should be 0.5, but got: %f", f)
+	}
+}
+
+func TestSlowStartSelect(t *testing.T) {
+	config := &slowStartConfig{Duration: 100, MinWeightPercent: 10, Aggression: 1}
+
+	for _, method := range []string{LBMWRR, LBMRR, LBMRandom} {
+		a := NewApp(getBalancer(method, NewBackend("192.168.1.1:80", 1)), true)
+		a.slowStart = config
+		a.AddBackend("192.168.1.2:80", 1, false, "")
+
+		status := a.BackendStatus()
+		if !status[1].SlowStart || status[1].EffectiveWeight >= 1 || status[0].SlowStart {
+			t.Errorf("only the new backend should be in slow start window, but got: %+v", status)
+		}
+
+		count := 0
+		for i := 0; i < 1000; i++ {
+			if b, _ := a.balancer.Select(); b.URL == "192.168.1.2:80" {
+				count++
+			}
+		}
+		// it should receive about 1/11 of the traffic
+		if count == 0 || count > 300 {
+			t.Errorf("%s: backend in slow start window receives %d of 1000 requests", method, count)
+		}
+	}
+
+	// backends in slow start window are selected if nothing else is available
+	b := NewBackend("192.168.1.1:80", 1)
+	b.stats.slowStart = config
+	b.stats.addedAt = CoarseTimeNow().Unix()
+	b2 := NewBackend("192.168.1.2:80", 1)
+	b2.stats.ejectedUntil = CoarseTimeNow().Unix() + 100
+	for _, balancer := range []Balancer{NewWRR(b, b2), NewRR(b, b2), NewRdm(b, b2)} {
+		for i := 0; i < 10; i++ {
+			if selected, found := balancer.Select(); !found || selected.URL != b.URL {
+				t.Errorf("%T should return the warming backend, but got: %+v, %t", balancer, selected, found)
+			}
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 	"net/http"
 	"sync/atomic"
+	"time"
 	"unsafe"
 )
 
@@ -29,6 +30,9 @@ type Status struct {
 	TooManyRequests uint32
 	InternalError   uint32
 	BadGateway      uint32
+	Requests        uint32 // requests whatever their status codes are, counted by addRequest
+	Failures        uint32 // requests which failed, see isFailure
+	Latency         uint64 // sum of latency of requests, in microseconds
 }
 
 // StatusRing return a ring of status
@@ -48,34 +52,34 @@ func StatusRing() *Status {
 	return head
 }
 
-// refreshStatus refresh the current status if it's outdate, and return the latest one
-func (n *node) refreshStatus(now int64) *Status {
-	status := n.status
+// refreshStatus refresh the current status of ring if it's outdate, and return the latest one.
+// head is where the current status is kept, e.g. status of a node.
+func refreshStatus(head **Status, now int64) *Status {
+	status := *head
 	if status.key != now {
 		if atomic.CompareAndSwapPointer(
-			// first, get address of n.status, means, address of `status field in n`, get it's address,
-			// cast it to `*unsafe.Pointer`
-			(*unsafe.Pointer)(unsafe.Pointer(&(n.status))), unsafe.Pointer(status), unsafe.Pointer(status.next),
+			// head is address of the field which holds the current status, cast it to `*unsafe.Pointer`
+			(*unsafe.Pointer)(unsafe.Pointer(head)), unsafe.Pointer(status), unsafe.Pointer(status.next),
 		) {
 			// clean old data, though it may cause some dirty reads
-			atomic.StoreInt64(&n.status.key, now)
-			atomic.StoreUint32(&n.status.OK, 0)
-			atomic.StoreUint32(&n.status.TooManyRequests, 0)
-			atomic.StoreUint32(&n.status.InternalError, 0)
-			atomic.StoreUint32(&n.status.BadGateway, 0)
+			status = status.next
+			atomic.StoreInt64(&status.key, now)
+			atomic.StoreUint32(&status.OK, 0)
+			atomic.StoreUint32(&status.TooManyRequests, 0)
+			atomic.StoreUint32(&status.InternalError, 0)
+			atomic.StoreUint32(&status.BadGateway, 0)
+			atomic.StoreUint32(&status.Requests, 0)
+			atomic.StoreUint32(&status.Failures, 0)
+			atomic.StoreUint64(&status.Latency, 0)
 		}
 	}
 
-	return n.status
+	return (*Status)(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(head))))
 }
 
-// incr increase by 1 on the given genericURL and status code, return value after incr
-func (n *node) incr(code int) uint32 {
-	if n.status == nil {
-		log.Panicf("status of node %+v is nil", n)
-	}
-
-	status := n.refreshStatus(RightNow())
+// incrStatus increase by 1 on the given status code, return value after incr
+func incrStatus(head **Status, code int) uint32 {
+	status := refreshStatus(head, RightNow())
 	switch code {
 	case http.StatusOK:
 		return atomic.AddUint32(&status.OK, 1)
@@ -91,12 +95,8 @@ func (n *node) incr(code int) uint32 {
 	}
 }
 
-func (n *node) query() (uint32, uint32, uint32, uint32, float64) {
-	if n.status == nil {
-		log.Panicf("status of node %+v is nil", n)
-	}
-
-	status := n.refreshStatus(RightNow())
+func queryStatus(head **Status) (uint32, uint32, uint32, uint32, float64) {
+	status := refreshStatus(head, RightNow())
 	ok, too, internal, bad := status.OK, status.TooManyRequests, status.InternalError, status.BadGateway
 
 	ratio := float64(
@@ -107,3 +107,58 @@ func (n *node) query() (uint32, uint32, uint32, uint32, float64) {
 
 	return ok, too, internal, bad, ratio
 }
+
+// addRequest count a request of any status code and add its latency to the current status
+func addRequest(head **Status, code int, latency time.Duration) {
+	status := refreshStatus(head, RightNow())
+	atomic.AddUint32(&status.Requests, 1)
+	if isFailure(code) {
+		atomic.AddUint32(&status.Failures, 1)
+	}
+	atomic.AddUint64(&status.Latency, uint64(latency/time.Microsecond))
+}
+
+// sumStatus return requests, failures and average latency in the latest windows, including
+// the current one, requests are those counted by addRequest
+func sumStatus(head **Status, windows int) (uint64, uint64, time.Duration) {
+	now := RightNow()
+	status := refreshStatus(head, now)
+
+	var requests, failures, latency uint64
+	for i := 0; i < windows && i < maxStatusLen; i++ {
+		if key := atomic.LoadInt64(&status.key); key > now-int64(windows)*statusStep && key <= now {
+			requests += uint64(atomic.LoadUint32(&status.Requests))
+			failures += uint64(atomic.LoadUint32(&status.Failures))
+			latency += atomic.LoadUint64(&status.Latency)
+		}
+		status = status.prev
+	}
+
+	if requests == 0 {
+		return 0, 0, 0
+	}
+
+	return requests, failures, time.Duration(latency/requests) * time.Microsecond
+}
+
+// refreshStatus refresh the current status if it's outdate, and return the latest one
+func (n *node) refreshStatus(now int64) *Status {
+	return refreshStatus(&n.status, now)
+}
+
+// incr increase by 1 on the given genericURL and status code, return value after incr
+func (n *node) incr(code int) uint32 {
+	if n.status == nil {
+		log.Panicf("status of node %+v is nil", n)
+	}
+
+	return incrStatus(&n.status, code)
+}
+
+func (n *node) query() (uint32, uint32, uint32, uint32, float64) {
+	if n.status == nil {
+		log.Panicf("status of node %+v is nil", n)
+	}
+
+	return queryStatus(&n.status)
+}
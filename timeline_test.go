@@ -3,6 +3,7 @@ package main
 import (
 	"net/http"
 	"testing"
+	"time"
 )
 
 func TestStatusRing(t *testing.T) {
@@ -151,3 +152,26 @@ func BenchmarkRightNow(b *testing.B) {
 		RightNow()
 	}
 }
+
+func TestSumStatus(t *testing.T) {
+	ring := StatusRing()
+	now := RightNow()
+
+	// the current window, the previous one, and a window which is too old
+	ring.key = now
+	ring.prev.key, ring.prev.Requests, ring.prev.Failures, ring.prev.Latency = now-statusStep, 4, 1, 4000
+	ring.prev.prev.key, ring.prev.prev.Requests = now-3*statusStep, 100
+
+	// status codes which have no buckets are counted too
+	addRequest(&ring, http.StatusNotFound, 2*time.Millisecond)
+	addRequest(&ring, http.StatusServiceUnavailable, 0)
+
+	requests, failures, latency := sumStatus(&ring, 2)
+	if requests != 6 || failures != 2 || latency != time.Millisecond {
+		t.Errorf("sum of status is wrong: %d, %d, %s", requests, failures, latency)
+	}
+
+	if requests, _, _ := sumStatus(&ring, 1); requests != 2 {
+		t.Errorf("requests of the current window should be 2, but got: %d", requests)
+	}
+}